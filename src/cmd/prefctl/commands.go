@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"pref"
+	"sort"
+)
+
+func runList(ctx *context, args []string) error {
+	if len(args) == 0 {
+		names, err := pref.Names()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	}
+	p, err := ctx.open(args[0])
+	if err != nil {
+		return err
+	}
+	all := p.GetAll()
+	for _, key := range sortedKeys(all) {
+		typeName, text := formatText(all[key])
+		fmt.Printf("%s\t%s\t%s\n", key, typeName, text)
+	}
+	return nil
+}
+
+func runGet(ctx *context, args []string) error {
+	p, err := ctx.open(args[0])
+	if err != nil {
+		return err
+	}
+	v, exist := p.GetAll()[args[1]]
+	if !exist {
+		return fmt.Errorf("key %s not found in %s", args[1], args[0])
+	}
+	_, text := formatText(v)
+	fmt.Println(text)
+	return nil
+}
+
+func runSet(ctx *context, args []string) error {
+	v, err := pref.ParseValue(ctx.typ, args[2])
+	if err != nil {
+		return err
+	}
+	p, err := ctx.open(args[0])
+	if err != nil {
+		return err
+	}
+	return commit(p.Edit().Put(args[1], v))
+}
+
+func runRemove(ctx *context, args []string) error {
+	p, err := ctx.open(args[0])
+	if err != nil {
+		return err
+	}
+	editor := p.Edit()
+	for _, key := range args[1:] {
+		editor.Remove(key)
+	}
+	return commit(editor)
+}
+
+func runClear(ctx *context, args []string) error {
+	p, err := ctx.open(args[0])
+	if err != nil {
+		return err
+	}
+	return commit(p.Edit().Clear())
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatText formats a value with the name of its type, the values of custom types are formatted by fmt.
+func formatText(v interface{}) (typeName string, text string) {
+	if typeName, text, err := pref.FormatValue(v); err == nil {
+		return typeName, text
+	}
+	return fmt.Sprintf("%T", v), fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,167 @@
+// Command prefctl inspects and edits the Preferences stored by the pref package.
+//
+// Usage:
+//
+//	prefctl <command> [arguments] [--dir=path] [--storage=file|log|dir]
+//
+// The format is json, yaml, toml, xml or gob, it is inferred from the extension of the file if not set, or
+// json by default. The commands are:
+//
+//	list [pref]                          list the Preferences, or the keys of a Preferences
+//	get <pref> <key>                     print the value of a key
+//	set <pref> <key> <value> [--type=t]  set the value of a key, the type is string by default
+//	rm <pref> <key>...                   remove keys
+//	clear <pref>                         remove all the keys
+//	dump <pref> [--format=f]             print all the key-values as json, yaml, toml or xml
+//	export <pref> [file] [--format=f]    write all the key-values to a file, or stdout
+//	import <pref> [file] [--format=f] [--mode=merge|replace|skip-existing]
+//	                                     read the key-values from a file, or stdin
+//	diff <a> <b>                         print the changes from a to b
+//	merge <base> <ours> <theirs> [--strategy=fail|ours|theirs|lww] [--dry-run]
+//	                                     merge the changes of theirs since base into ours
+//	watch <pref> [--key-prefix=p]        print the changes of the keys as JSON lines until interrupted
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pref"
+	"sort"
+	"strings"
+	"time"
+)
+
+// command is a subcommand of prefctl.
+type command struct {
+	usage string
+	// nargs is the minimum number of arguments, and max is the maximum or -1 if unlimited.
+	nargs, max int
+	run        func(ctx *context, args []string) error
+}
+
+// context keeps the flags shared by all the commands.
+type context struct {
+	flags   *flag.FlagSet
+	dir     string
+	storage string
+	typ     string
+	format  string
+	mode    string
+	// strategy and dryRun are the flags of merge.
+	strategy string
+	dryRun   bool
+	// keyPrefix and interval are the flags of watch.
+	keyPrefix string
+	interval  time.Duration
+}
+
+var commands = map[string]command{
+	"list":   {"list [pref]", 0, 1, runList},
+	"get":    {"get <pref> <key>", 2, 2, runGet},
+	"set":    {"set <pref> <key> <value> [--type=string]", 3, 3, runSet},
+	"rm":     {"rm <pref> <key>...", 2, -1, runRemove},
+	"clear":  {"clear <pref>", 1, 1, runClear},
+	"dump":   {"dump <pref> [--format=json|yaml|toml|xml]", 1, 1, runDump},
+	"export": {"export <pref> [file] [--format=json|yaml|toml|xml|gob]", 1, 2, runExport},
+	"import": {"import <pref> [file] [--format=json|yaml|toml|xml|gob] [--mode=merge|replace|skip-existing]", 1, 2, runImport},
+	"diff":   {"diff <a> <b>", 2, 2, runDiff},
+	"merge":  {"merge <base> <ours> <theirs> [--strategy=fail|ours|theirs|lww] [--dry-run]", 3, 3, runMerge},
+	"watch":  {"watch <pref> [--key-prefix=prefix] [--interval=1s]", 1, 1, runWatch},
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "prefctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		return usageError()
+	}
+	cmd, exist := commands[args[0]]
+	if !exist {
+		return usageError()
+	}
+	ctx := newContext(args[0])
+	positional, err := parseArgs(ctx.flags, args[1:])
+	if err != nil {
+		return err
+	}
+	if len(positional) < cmd.nargs || cmd.max >= 0 && len(positional) > cmd.max {
+		return fmt.Errorf("usage: prefctl %s", cmd.usage)
+	}
+	// The pref package joins the base path and the names directly.
+	pref.InitBasePath(filepath.Clean(ctx.dir) + string(filepath.Separator))
+	return cmd.run(ctx, positional)
+}
+
+func usageError() error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, "  prefctl "+commands[name].usage)
+	}
+	return fmt.Errorf("usage:\n%s\nflags: --dir=path --storage=file|log|dir", strings.Join(lines, "\n"))
+}
+
+func newContext(name string) *context {
+	ctx := &context{flags: flag.NewFlagSet(name, flag.ContinueOnError)}
+	ctx.flags.StringVar(&ctx.dir, "dir", ".", "the base path of the Preferences")
+	ctx.flags.StringVar(&ctx.storage, "storage", "file", "the storage of the Preferences: file, log or dir")
+	ctx.flags.StringVar(&ctx.typ, "type", "string", "the type of the value to set")
+	ctx.flags.StringVar(&ctx.format, "format", "", "the format to dump, export or import: json, yaml, toml, xml or gob")
+	ctx.flags.StringVar(&ctx.mode, "mode", "merge", "the mode to import: merge, replace or skip-existing")
+	ctx.flags.StringVar(&ctx.strategy, "strategy", "fail", "the resolution of merge conflicts: fail, ours, theirs or lww")
+	ctx.flags.BoolVar(&ctx.dryRun, "dry-run", false, "print the changes of merge without writing them")
+	ctx.flags.StringVar(&ctx.keyPrefix, "key-prefix", "", "only watch the keys with the prefix")
+	ctx.flags.DurationVar(&ctx.interval, "interval", time.Second, "the interval to check the storage for changes")
+	return ctx
+}
+
+// parseArgs parses the flags which may appear before, between or after the positional arguments, and
+// returns the positional ones.
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// open opens the Preferences with the storage selected by the flags.
+func (ctx *context) open(name string) (pref.Preferences, error) {
+	options := make([]pref.Option, 0)
+	switch ctx.storage {
+	case "file":
+	case "log":
+		options = append(options, pref.WithLogStorage(pref.DefaultCompactThreshold))
+	case "dir":
+		options = append(options, pref.WithDirStorage())
+	default:
+		return nil, fmt.Errorf("unknown storage %s", ctx.storage)
+	}
+	return pref.NewPreferences(name, options...), nil
+}
+
+// commit commits the changes of editor, and returns the reason if it failed.
+func commit(editor pref.Editor) error {
+	if !editor.Commit() {
+		return editor.Err()
+	}
+	return nil
+}
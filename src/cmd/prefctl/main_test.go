@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"pref"
+	"testing"
+)
+
+type MainTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (suite *MainTestSuite) SetupTest() {
+	suite.dir, _ = ioutil.TempDir("", "prefctl")
+}
+
+func (suite *MainTestSuite) TearDownTest() {
+	removeDir(suite.dir)
+}
+
+// removeDir releases the Preferences stored in dir and removes it, so the tests can run again with new
+// directories in the same process.
+func removeDir(dir string) {
+	pref.InitBasePath(dir + string(filepath.Separator))
+	names, _ := pref.Names()
+	for _, name := range names {
+		pref.Release(name)
+	}
+	os.RemoveAll(dir)
+}
+
+func TestMainTestSuite(t *testing.T) {
+	suite.Run(t, new(MainTestSuite))
+}
+
+func (suite *MainTestSuite) TestParseArgs() {
+	ctx := newContext("set")
+	args, err := parseArgs(ctx.flags, []string{"--dir=/tmp", "app", "key", "--type=int", "3"})
+	suite.Nil(err)
+	suite.Equal([]string{"app", "key", "3"}, args)
+	suite.Equal("/tmp", ctx.dir)
+	suite.Equal("int", ctx.typ)
+}
+
+func (suite *MainTestSuite) TestSetAndRemove() {
+	suite.Nil(run([]string{"set", "main_test", "count", "3", "--type=int", "--dir=" + suite.dir}))
+	suite.Nil(run([]string{"set", "main_test", "name", "value", "--dir=" + suite.dir}))
+	p := pref.NewPreferences("main_test")
+	suite.Equal(3, p.GetInt("count", 0))
+	suite.Equal("value", p.GetString("name", ""))
+
+	suite.Nil(run([]string{"rm", "main_test", "count", "--dir=" + suite.dir}))
+	suite.False(p.Contains("count"))
+	suite.Nil(run([]string{"clear", "main_test", "--dir=" + suite.dir}))
+	suite.Empty(p.GetAll())
+}
+
+func (suite *MainTestSuite) TestErrors() {
+	suite.Error(run(nil))
+	suite.Error(run([]string{"unknown"}))
+	suite.Error(run([]string{"get", "main_test"}))
+	suite.Error(run([]string{"set", "main_test", "count", "x", "--type=int", "--dir=" + suite.dir}))
+	suite.Error(run([]string{"list", "main_test", "--storage=none", "--dir=" + suite.dir}))
+}
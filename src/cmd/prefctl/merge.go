@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"pref"
+	"sort"
+	"strings"
+	"time"
+)
+
+func runDiff(ctx *context, args []string) error {
+	a, err := ctx.open(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := ctx.open(args[1])
+	if err != nil {
+		return err
+	}
+	return printChanges(os.Stdout, pref.Diff(a, b))
+}
+
+func runMerge(ctx *context, args []string) error {
+	prefs := make([]pref.Preferences, len(args))
+	for i, name := range args {
+		p, err := ctx.open(name)
+		if err != nil {
+			return err
+		}
+		prefs[i] = p
+	}
+	conflicts := make([]string, 0)
+	var resolve pref.Resolver
+	switch ctx.strategy {
+	case "fail":
+		resolve = func(c pref.Conflict) (interface{}, error) {
+			conflicts = append(conflicts, c.Key)
+			return c.Ours, nil
+		}
+	case "ours":
+		resolve = pref.ResolveOurs
+	case "theirs":
+		resolve = pref.ResolveTheirs
+	case "lww":
+		resolve = pref.LastWriterWins(ctx.modTime(args[1]), ctx.modTime(args[2]))
+	default:
+		return fmt.Errorf("unknown strategy %s", ctx.strategy)
+	}
+	changes, err := pref.Merge(prefs[0], prefs[1], prefs[2], resolve)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("conflicting keys: %s", strings.Join(conflicts, ", "))
+	}
+	if err := printChanges(os.Stdout, changes); err != nil || ctx.dryRun || len(changes) == 0 {
+		return err
+	}
+	return commit(pref.ApplyChanges(prefs[1].Edit(), changes))
+}
+
+// printChanges writes a line for each change, prefixed by "+" if the key is added, "-" if it is removed,
+// or "~" if it is modified.
+func printChanges(w io.Writer, changes []pref.Change) error {
+	for _, c := range changes {
+		var line string
+		switch c.Kind {
+		case pref.Added:
+			_, text := formatText(c.New)
+			line = fmt.Sprintf("+ %s = %s", c.Key, text)
+		case pref.Removed:
+			_, text := formatText(c.Old)
+			line = fmt.Sprintf("- %s = %s", c.Key, text)
+		default:
+			_, old := formatText(c.Old)
+			_, text := formatText(c.New)
+			line = fmt.Sprintf("~ %s = %s -> %s", c.Key, old, text)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modTime returns the latest modification time of the files stored by any storage of a Preferences.
+func (ctx *context) modTime(name string) time.Time {
+	var latest time.Time
+	base := filepath.Join(ctx.dir, name)
+	for _, path := range []string{base, base + ".log"} {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"pref"
+	"testing"
+	"time"
+)
+
+type MergeTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (suite *MergeTestSuite) SetupTest() {
+	suite.dir, _ = ioutil.TempDir("", "prefctl")
+}
+
+func (suite *MergeTestSuite) TearDownTest() {
+	removeDir(suite.dir)
+}
+
+func TestMergeTestSuite(t *testing.T) {
+	suite.Run(t, new(MergeTestSuite))
+}
+
+func (suite *MergeTestSuite) set(name string, args ...string) {
+	for i := 0; i < len(args); i += 2 {
+		suite.Nil(run([]string{"set", name, args[i], args[i+1], "--dir=" + suite.dir}))
+	}
+}
+
+func (suite *MergeTestSuite) TestPrintChanges() {
+	var buf bytes.Buffer
+	suite.Nil(printChanges(&buf, []pref.Change{
+		{Key: "a", Kind: pref.Added, New: 1},
+		{Key: "b", Kind: pref.Removed, Old: "x"},
+		{Key: "c", Kind: pref.Modified, Old: true, New: false},
+	}))
+	suite.Equal("+ a = 1\n- b = x\n~ c = true -> false\n", buf.String())
+}
+
+func (suite *MergeTestSuite) TestMerge() {
+	dir := "--dir=" + suite.dir
+	suite.set("merge_base", "key", "1", "conflict", "1")
+	suite.set("merge_ours", "key", "1", "conflict", "2")
+	time.Sleep(10 * time.Millisecond)
+	suite.set("merge_theirs", "key", "3", "conflict", "3")
+
+	suite.Error(run([]string{"merge", "merge_base", "merge_ours", "merge_theirs", dir}))
+	suite.Nil(run([]string{"merge", "merge_base", "merge_ours", "merge_theirs", "--strategy=ours", "--dry-run", dir}))
+	ours := pref.NewPreferences("merge_ours")
+	suite.Equal("1", ours.GetString("key", ""))
+
+	suite.Nil(run([]string{"merge", "merge_base", "merge_ours", "merge_theirs", "--strategy=lww", dir}))
+	suite.Equal("3", ours.GetString("key", ""))
+	suite.Equal("3", ours.GetString("conflict", ""))
+	suite.Nil(run([]string{"diff", "merge_ours", "merge_theirs", dir}))
+	suite.Empty(pref.Diff(ours, pref.NewPreferences("merge_theirs")))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"pref"
+	"strings"
+)
+
+var importModes = map[string]pref.ImportMode{
+	"merge":         pref.ImportMerge,
+	"replace":       pref.ImportReplace,
+	"skip-existing": pref.ImportSkipExisting,
+}
+
+func runDump(ctx *context, args []string) error {
+	if ctx.format == string(pref.FormatGob) {
+		return fmt.Errorf("cannot dump gob, use export instead")
+	}
+	p, err := ctx.open(args[0])
+	if err != nil {
+		return err
+	}
+	return pref.Export(p, os.Stdout, ctx.formatOf(""))
+}
+
+func runExport(ctx *context, args []string) error {
+	p, err := ctx.open(args[0])
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		return pref.Export(p, os.Stdout, ctx.formatOf(""))
+	}
+	file, err := os.Create(args[1])
+	if err != nil {
+		return err
+	}
+	if err := pref.Export(p, file, ctx.formatOf(args[1])); err != nil {
+		file.Close()
+		os.Remove(args[1])
+		return err
+	}
+	return file.Close()
+}
+
+func runImport(ctx *context, args []string) error {
+	mode, exist := importModes[ctx.mode]
+	if !exist {
+		return fmt.Errorf("unknown import mode %s", ctx.mode)
+	}
+	var r io.Reader = os.Stdin
+	path := ""
+	if len(args) == 2 {
+		path = args[1]
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	p, err := ctx.open(args[0])
+	if err != nil {
+		return err
+	}
+	editor := p.Edit()
+	if err := pref.Import(editor, r, ctx.formatOf(path), mode); err != nil {
+		return err
+	}
+	return commit(editor)
+}
+
+// formatOf returns the format set by the flag, or the one inferred from the extension of path.
+func (ctx *context) formatOf(path string) pref.Format {
+	if ctx.format != "" {
+		return pref.Format(ctx.format)
+	}
+	switch ext := strings.TrimPrefix(filepath.Ext(path), "."); ext {
+	case "yml":
+		return pref.FormatYAML
+	case "json", "yaml", "toml", "xml", "gob":
+		return pref.Format(ext)
+	}
+	return pref.FormatJSON
+}
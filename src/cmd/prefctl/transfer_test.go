@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"path/filepath"
+	"pref"
+	"testing"
+)
+
+type TransferTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (suite *TransferTestSuite) SetupTest() {
+	suite.dir, _ = ioutil.TempDir("", "prefctl")
+}
+
+func (suite *TransferTestSuite) TearDownTest() {
+	removeDir(suite.dir)
+}
+
+func TestTransferTestSuite(t *testing.T) {
+	suite.Run(t, new(TransferTestSuite))
+}
+
+func (suite *TransferTestSuite) TestExportImport() {
+	dir := "--dir=" + suite.dir
+	suite.Nil(run([]string{"set", "transfer_from", "count", "3", "--type=int64", dir}))
+	suite.Nil(run([]string{"set", "transfer_from", "name", "value", dir}))
+	for _, name := range []string{"settings.gob", "settings.yml", "settings.toml"} {
+		path := filepath.Join(suite.dir, name)
+		suite.Nil(run([]string{"export", "transfer_from", path, dir}))
+		suite.Nil(run([]string{"import", "transfer_to", path, "--mode=replace", dir}))
+		p := pref.NewPreferences("transfer_to")
+		suite.Equal("value", p.GetString("name", ""))
+		suite.True(p.Contains("count"))
+	}
+	// Only gob keeps the type.
+	suite.Nil(run([]string{"import", "transfer_to", filepath.Join(suite.dir, "settings.gob"), dir}))
+	suite.Equal(int64(3), pref.NewPreferences("transfer_to").GetInt64("count", 0))
+}
+
+func (suite *TransferTestSuite) TestFormatOf() {
+	ctx := newContext("export")
+	suite.Equal(pref.FormatYAML, ctx.formatOf("a.yml"))
+	suite.Equal(pref.FormatXML, ctx.formatOf("a.xml"))
+	suite.Equal(pref.FormatJSON, ctx.formatOf(""))
+	ctx.format = "toml"
+	suite.Equal(pref.FormatTOML, ctx.formatOf("a.xml"))
+}
+
+func (suite *TransferTestSuite) TestErrors() {
+	dir := "--dir=" + suite.dir
+	suite.Error(run([]string{"import", "transfer_to", "--mode=append", dir}))
+	suite.Error(run([]string{"import", "transfer_to", filepath.Join(suite.dir, "missing.json"), dir}))
+	suite.Error(run([]string{"dump", "transfer_to", "--format=gob", dir}))
+}
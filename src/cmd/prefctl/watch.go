@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"os/signal"
+	"pref"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// changeEvent is a line printed by watch, a nil value means the key does not exist.
+type changeEvent struct {
+	Time string      `json:"time"`
+	Key  string      `json:"key"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+func runWatch(ctx *context, args []string) error {
+	p, err := ctx.open(args[0])
+	if err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		<-signals
+		close(done)
+	}()
+	return watch(p, os.Stdout, ctx.keyPrefix, ctx.interval, done)
+}
+
+// watch writes a changeEvent to w for every change of the keys with the prefix, until done is closed. The
+// storage is checked for the modifications made by other processes on every interval.
+func watch(p pref.Preferences, w io.Writer, prefix string, interval time.Duration, done <-chan struct{}) error {
+	ch := make(chan string, 64)
+	p.RegisterOnPreferenceChangeListener(ch)
+	defer p.UnregisterOnPreferenceChangeListener(ch)
+	if watcher, ok := p.(interface {
+		Watch(time.Duration) func()
+	}); ok {
+		stop := watcher.Watch(interval)
+		defer stop()
+	}
+	last := p.GetAll()
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for {
+		select {
+		case <-done:
+			return nil
+		case key := <-ch:
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			// Only the key is updated, the other keys changed by the same commit have their own events.
+			old := last[key]
+			cur, exist := p.GetAll()[key]
+			if exist {
+				last[key] = cur
+			} else {
+				delete(last, key)
+			}
+			if reflect.DeepEqual(old, cur) {
+				continue
+			}
+			event := changeEvent{
+				Time: time.Now().Format(time.RFC3339Nano),
+				Key:  key,
+				Old:  jsonValue(old),
+				New:  jsonValue(cur),
+			}
+			if err := enc.Encode(&event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// jsonValue returns the value to be encoded as JSON, a RawValue is written as its description.
+func jsonValue(v interface{}) interface{} {
+	if raw, ok := v.(pref.RawValue); ok {
+		return raw.String()
+	}
+	return v
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"pref"
+	"testing"
+	"time"
+)
+
+type WatchTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (suite *WatchTestSuite) SetupTest() {
+	suite.dir, _ = ioutil.TempDir("", "prefctl")
+	pref.InitBasePath(suite.dir + string(filepath.Separator))
+}
+
+func (suite *WatchTestSuite) TearDownTest() {
+	removeDir(suite.dir)
+}
+
+func TestWatchTestSuite(t *testing.T) {
+	suite.Run(t, new(WatchTestSuite))
+}
+
+func (suite *WatchTestSuite) TestWatch() {
+	p := pref.NewPreferences("watch_test")
+	p.Edit().Put("app.name", "old").Put("other", 1).Commit()
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	go watch(p, w, "app.", 10*time.Millisecond, done)
+	defer close(done)
+	// Wait for the listener to be registered.
+	time.Sleep(50 * time.Millisecond)
+
+	p.Edit().Put("other", 2).Commit()
+	p.Edit().Put("app.name", "new").Commit()
+	var event changeEvent
+	suite.Nil(json.NewDecoder(r).Decode(&event))
+	suite.Equal("app.name", event.Key)
+	suite.Equal("old", event.Old)
+	suite.Equal("new", event.New)
+	suite.NotEmpty(event.Time)
+}
+
+func (suite *WatchTestSuite) TestWatchMultipleKeys() {
+	p := pref.NewPreferences("watch_test")
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	go watch(p, w, "app.", 10*time.Millisecond, done)
+	defer close(done)
+	// Wait for the listener to be registered.
+	time.Sleep(50 * time.Millisecond)
+
+	p.Edit().Put("app.a", 1).Put("app.b", 2).Commit()
+	events := make(map[string]interface{})
+	dec := json.NewDecoder(r)
+	for i := 0; i < 2; i++ {
+		var event changeEvent
+		suite.Nil(dec.Decode(&event))
+		suite.Nil(event.Old)
+		events[event.Key] = event.New
+	}
+	suite.Equal(map[string]interface{}{"app.a": float64(1), "app.b": float64(2)}, events)
+}
@@ -0,0 +1,343 @@
+package pref
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// journalSuffix is the suffix of the journal files written by Batch.
+const journalSuffix = ".journal"
+
+var (
+	// journalLock serializes writing the journals and recovering from them.
+	journalLock = new(sync.Mutex)
+	// journalSeq makes the names of the journals unique in this process.
+	journalSeq uint64
+	// errUnsupportedEditor is returned when an editor not created by PreferencesImpl is added to a Batch.
+	errUnsupportedEditor = errors.New("pref: batch only supports the editors of PreferencesImpl")
+)
+
+// journalEntry keeps the records of one Preferences in a journal.
+type journalEntry struct {
+	Name    string
+	Records []Record
+}
+
+// encodedJournalEntry is a journalEntry whose values have been encoded by encodeValue.
+type encodedJournalEntry struct {
+	Name    string
+	Records []encodedRecord
+}
+
+// Batch commits the editors of different Preferences atomically, either all or none of their changes are
+// applied. Before any storage is written, the records of all the editors are written to a journal file
+// under basePath, so a batch interrupted by a crash is completed when its Preferences are loaded again. If
+// a storage fails to save, the journal keeps the records of that storage until it is brought up to date by
+// the next save of its Preferences, and the records are replaced by the ones of every later save before it
+// is written, so replaying the journal after a crash never reverts a later commit.
+type Batch struct {
+	editors []*EditorImpl
+	err     error
+}
+
+// NewBatch creates an empty batch.
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+// Add adds an editor to the batch, at most one editor of each Preferences can be added.
+func (b *Batch) Add(editor Editor) *Batch {
+	e, ok := editor.(*EditorImpl)
+	if !ok {
+		b.err = errUnsupportedEditor
+		return b
+	}
+	for _, added := range b.editors {
+		if added.pref == e.pref {
+			b.err = fmt.Errorf("pref: batch has more than one editor of preference %s", e.pref.name)
+			return b
+		}
+	}
+	b.editors = append(b.editors, e)
+	return b
+}
+
+// Commit submits the changes of all the editors to memory and disk synchronously. If the conditions of any
+// editor fail, nothing is applied and its error is returned, which is also returned by its Err. The editors
+// keep their changes and conditions after a failed batch as if it had not been committed.
+func (b *Batch) Commit() error {
+	if b.err != nil {
+		return b.err
+	}
+	// Lock the Preferences in the order of names to avoid deadlock with other batches.
+	editors := make([]*EditorImpl, len(b.editors))
+	copy(editors, b.editors)
+	sort.Slice(editors, func(i, j int) bool {
+		return editors[i].pref.name < editors[j].pref.name
+	})
+	for _, e := range editors {
+		e.Lock()
+		defer e.Unlock()
+	}
+	for _, e := range editors {
+		e.pref.Lock()
+	}
+	unlock := func() {
+		for _, e := range editors {
+			e.pref.Unlock()
+		}
+	}
+
+	maps := make([]map[string]interface{}, len(editors))
+	keys := make([][]string, len(editors))
+	records := make([][]Record, len(editors))
+	entries := make([]journalEntry, 0, len(editors))
+	// prepareLocked resolves the increments and drops the conditions of editors, which must be kept by the
+	// editors if the batch fails, so that they can be committed again.
+	pending := make([]editorChanges, len(editors))
+	restore := func() {
+		for i, e := range editors {
+			e.restoreChangesLocked(pending[i])
+		}
+	}
+	for i, e := range editors {
+		pending[i] = e.changesLocked()
+		maps[i], keys[i], records[i] = e.prepareLocked()
+		if e.err != nil {
+			err := e.err
+			restore()
+			unlock()
+			return err
+		}
+		if len(keys[i]) > 0 {
+			entries = append(entries, journalEntry{Name: e.pref.name, Records: records[i]})
+		}
+	}
+	if len(entries) == 0 {
+		unlock()
+		return nil
+	}
+
+	path := fmt.Sprintf("%sbatch_%d_%d%s", editors[0].pref.journalDir, time.Now().UnixNano(), atomic.AddUint64(&journalSeq, 1), journalSuffix)
+	journalLock.Lock()
+	err := writeJournal(path, entries)
+	journalLock.Unlock()
+	if err != nil {
+		os.Remove(path)
+		restore()
+		unlock()
+		return err
+	}
+	for i, e := range editors {
+		e.publishLocked(maps[i], keys[i])
+	}
+	result := make(chan error, 1)
+	executor.Execute(func() {
+		var err error
+		failed := make([]journalEntry, 0)
+		behind := make([]*PreferencesImpl, 0)
+		for i, e := range editors {
+			if len(keys[i]) == 0 {
+				continue
+			}
+			if saveErr := e.pref.commitToDisk(maps[i], records[i]); saveErr != nil {
+				if err == nil {
+					err = saveErr
+				}
+				failed = append(failed, journalEntry{Name: e.pref.name, Records: records[i]})
+				behind = append(behind, e.pref)
+			}
+		}
+		// Only the records of the failed saves are kept, the other storages must not replay them over their
+		// later commits.
+		journalLock.Lock()
+		if journalErr := replaceJournal(path, failed); journalErr != nil {
+			log.Printf("Error when update the journal %s: %v", path, journalErr)
+		}
+		journalLock.Unlock()
+		for _, p := range behind {
+			p.diskLock.Lock()
+			p.journals = append(p.journals, path)
+			p.diskLock.Unlock()
+		}
+		result <- err
+	})
+	for i, e := range editors {
+		e.pref.notifyObservers(keys[i])
+	}
+	unlock()
+	err = <-result
+	for _, e := range editors {
+		e.err = err
+	}
+	return err
+}
+
+// editorChanges is a copy of the changes and conditions of an editor which have not been committed.
+type editorChanges struct {
+	modified   map[string]interface{}
+	expected   map[string]interface{}
+	increments map[string]int64
+}
+
+// changesLocked returns a copy of the changes and conditions of editor.
+func (e *EditorImpl) changesLocked() editorChanges {
+	c := editorChanges{
+		modified:   make(map[string]interface{}, len(e.modified)),
+		expected:   make(map[string]interface{}, len(e.expected)),
+		increments: make(map[string]int64, len(e.increments)),
+	}
+	for k, v := range e.modified {
+		c.modified[k] = v
+	}
+	for k, v := range e.expected {
+		c.expected[k] = v
+	}
+	for k, delta := range e.increments {
+		c.increments[k] = delta
+	}
+	return c
+}
+
+// restoreChangesLocked restores the changes and conditions of editor copied by changesLocked.
+func (e *EditorImpl) restoreChangesLocked(c editorChanges) {
+	e.modified = c.modified
+	e.expected = c.expected
+	e.increments = c.increments
+}
+
+// recoverJournals applies the records of this Preferences left in the journals by the batches interrupted
+// by a crash, m is the key-values just loaded from storage.
+func (p *PreferencesImpl) recoverJournals(m map[string]interface{}) {
+	journalLock.Lock()
+	defer journalLock.Unlock()
+	paths, _ := filepath.Glob(p.journalDir + "*" + journalSuffix)
+	for _, path := range paths {
+		entries, err := readJournal(path)
+		if err == io.EOF || err == io.ErrUnexpectedEOF || err == errCorruptedFrame {
+			// The journal was torn before any storage was written, so none of the changes is applied.
+			log.Printf("Discard the incomplete journal %s: %v", path, err)
+			os.Remove(path)
+			continue
+		} else if err != nil {
+			log.Printf("Error when read the journal %s: %v", path, err)
+			continue
+		}
+		rest := make([]journalEntry, 0, len(entries))
+		for _, entry := range entries {
+			if entry.Name != p.name {
+				rest = append(rest, entry)
+				continue
+			}
+			// Replaying the records is harmless if they have already been saved before the crash.
+			applyRecords(m, entry.Records)
+			if err := p.storage.Save(m, entry.Records); err != nil {
+				log.Printf("Error when recover the preference %s from journal: %v", p.name, err)
+				rest = append(rest, entry)
+			}
+		}
+		if len(rest) < len(entries) {
+			if err := replaceJournal(path, rest); err != nil {
+				log.Printf("Error when update the journal %s: %v", path, err)
+			}
+		}
+	}
+}
+
+// updateJournals replaces the records of this Preferences in the journals of the failed batches, or removes
+// them if records is nil. It must be called with diskLock held.
+func (p *PreferencesImpl) updateJournals(records []Record) {
+	journalLock.Lock()
+	defer journalLock.Unlock()
+	for _, path := range p.journals {
+		entries, err := readJournal(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			log.Printf("Error when read the journal %s: %v", path, err)
+			continue
+		}
+		rest := make([]journalEntry, 0, len(entries))
+		for _, entry := range entries {
+			if entry.Name == p.name {
+				if records == nil {
+					continue
+				}
+				entry.Records = records
+			}
+			rest = append(rest, entry)
+		}
+		if err := replaceJournal(path, rest); err != nil {
+			log.Printf("Error when update the journal %s: %v", path, err)
+		}
+	}
+}
+
+// replaceJournal replaces the journal at path with the entries atomically, the journal is removed if there
+// is no entry. It must be called with journalLock held.
+func replaceJournal(path string, entries []journalEntry) error {
+	if len(entries) == 0 {
+		return removeFile(path)
+	}
+	tmpPath := path + ".tmp"
+	if err := writeJournal(tmpPath, entries); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func writeJournal(path string, entries []journalEntry) error {
+	encoded := make([]encodedJournalEntry, len(entries))
+	for i, entry := range entries {
+		records, err := encodeRecords(entry.Records)
+		if err != nil {
+			return err
+		}
+		encoded[i] = encodedJournalEntry{Name: entry.Name, Records: records}
+	}
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(encoded); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(frame(data.Bytes())); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func readJournal(path string) ([]journalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := readFrame(file)
+	if err != nil {
+		return nil, err
+	}
+	var encoded []encodedJournalEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&encoded); err != nil {
+		return nil, err
+	}
+	entries := make([]journalEntry, len(encoded))
+	for i, entry := range encoded {
+		entries[i] = journalEntry{Name: entry.Name, Records: decodeRecords(entry.Records)}
+	}
+	return entries, nil
+}
@@ -0,0 +1,192 @@
+package pref
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const OtherPrefName = "pref_unit_other"
+
+type BatchTestSuite struct {
+	suite.Suite
+	other *PreferencesImpl
+}
+
+func (suite *BatchTestSuite) SetupTest() {
+	basePath = "./"
+	pref = newPreferencesImpl(PrefName)
+	suite.other = newPreferencesImpl(OtherPrefName)
+}
+
+func (suite *BatchTestSuite) TearDownTest() {
+	for _, name := range []string{PrefName, OtherPrefName} {
+		os.Remove(basePath + name)
+		os.Remove(basePath + name + "_bak")
+	}
+	paths, _ := filepath.Glob(basePath + "*" + journalSuffix)
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
+
+func TestBatchTestSuite(t *testing.T) {
+	suite.Run(t, new(BatchTestSuite))
+}
+
+func load(name string, options ...Option) *PreferencesImpl {
+	p := newPreferencesImpl(name)
+	for _, option := range options {
+		option(p)
+	}
+	p.loadWg.Add(1)
+	go p.loadFromFile()
+	p.loadWg.Wait()
+	return p
+}
+
+// storageOptions select every storage backend, for the tests of the values which every storage must be able
+// to store.
+var storageOptions = map[string]Option{
+	"file": func(*PreferencesImpl) {},
+	"log":  WithLogStorage(DefaultCompactThreshold),
+	"dir":  WithDirStorage(),
+}
+
+// removeStorages removes the files of name stored by any storage backend.
+func removeStorages(name string) {
+	for _, suffix := range []string{"", "_bak", ".log"} {
+		os.RemoveAll(basePath + name + suffix)
+	}
+}
+
+func (suite *BatchTestSuite) journals() []string {
+	paths, _ := filepath.Glob(basePath + "*" + journalSuffix)
+	return paths
+}
+
+func (suite *BatchTestSuite) TestCommit() {
+	ch := make(chan string, 1)
+	suite.other.RegisterOnPreferenceChangeListener(ch)
+	err := NewBatch().Add(pref.Edit().Put("key1", 1)).Add(suite.other.Edit().Put("key2", "value")).Commit()
+	suite.Nil(err)
+	suite.Equal(pref.GetInt("key1", 0), 1)
+	suite.Equal(suite.other.GetString("key2", ""), "value")
+	suite.Equal(<-ch, "key2")
+	suite.Empty(suite.journals())
+	suite.Equal(load(PrefName).values()["key1"], 1)
+	suite.Equal(load(OtherPrefName).values()["key2"], "value")
+}
+
+func (suite *BatchTestSuite) TestConditionFailed() {
+	pref.Edit().Put("key1", 1).Commit()
+	e := suite.other.Edit().CompareAndPut("key2", "expected", "value")
+	err := NewBatch().Add(pref.Edit().Put("key1", 2)).Add(e).Commit()
+	suite.Equal(err, &ConditionError{Keys: []string{"key2"}})
+	suite.Equal(e.Err(), err)
+	suite.Equal(pref.GetInt("key1", 0), 1)
+	suite.False(suite.other.Contains("key2"))
+	suite.Empty(suite.journals())
+}
+
+func (suite *BatchTestSuite) TestConditionFailedKeepsEditors() {
+	pref.Edit().Put("count", 5).Commit()
+	e := pref.Edit().Increment("count", 1).CompareAndPut("key1", nil, 1)
+	err := NewBatch().Add(e).Add(suite.other.Edit().CompareAndPut("key2", "expected", "value")).Commit()
+	suite.Error(err)
+
+	pref.Edit().Put("count", 100).Commit()
+	suite.Nil(NewBatch().Add(e).Commit())
+	suite.Equal(101, pref.GetInt("count", 0))
+	suite.Equal(1, pref.GetInt("key1", 0))
+}
+
+func (suite *BatchTestSuite) TestInvalidEditors() {
+	e := pref.Edit()
+	suite.Error(NewBatch().Add(e).Add(pref.Edit()).Commit())
+	suite.Error(NewBatch().Add(nil).Commit())
+}
+
+func (suite *BatchTestSuite) TestRecover() {
+	pref.Edit().Put("key1", 1).Put("key2", 2).Commit()
+	entries := []journalEntry{
+		{Name: PrefName, Records: []Record{{Op: OpPut, Key: "key1", Value: 3}, {Op: OpRemove, Key: "key2"}}},
+		{Name: OtherPrefName, Records: []Record{{Op: OpPut, Key: "key3", Value: "value"}}},
+	}
+	path := basePath + "batch_test" + journalSuffix
+	suite.Nil(writeJournal(path, entries))
+
+	p := load(PrefName)
+	suite.Equal(p.values(), map[string]interface{}{"key1": 3})
+	suite.Equal(suite.journals(), []string{filepath.Clean(path)})
+	suite.Equal(load(PrefName).values(), map[string]interface{}{"key1": 3})
+	suite.Equal(load(OtherPrefName).values(), map[string]interface{}{"key3": "value"})
+	suite.Empty(suite.journals())
+}
+
+func (suite *BatchTestSuite) TestDiscardTornJournal() {
+	entries := []journalEntry{{Name: PrefName, Records: []Record{{Op: OpPut, Key: "key1", Value: 3}}}}
+	path := basePath + "batch_test" + journalSuffix
+	suite.Nil(writeJournal(path, entries))
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-1)
+
+	suite.Empty(load(PrefName).values())
+	suite.Empty(suite.journals())
+}
+
+// failingStorage fails to save while fail is set.
+type failingStorage struct {
+	Storage
+	fail bool
+}
+
+func (s *failingStorage) Save(m map[string]interface{}, records []Record) error {
+	if s.fail {
+		return errors.New("disk full")
+	}
+	return s.Storage.Save(m, records)
+}
+
+func (suite *BatchTestSuite) TestStorageFailed() {
+	storage := &failingStorage{Storage: newFileStorage(basePath + PrefName), fail: true}
+	WithStorage(storage)(pref)
+	err := NewBatch().Add(pref.Edit().Put("key1", 1).Put("key2", 2)).Add(suite.other.Edit().Put("key3", 3)).Commit()
+	suite.Error(err)
+	// The journal only keeps the records of the failed storage.
+	suite.Len(suite.journals(), 1)
+	entries, err := readJournal(suite.journals()[0])
+	suite.Nil(err)
+	suite.Len(entries, 1)
+	suite.Equal(PrefName, entries[0].Name)
+
+	// The next commit saves the changes missed by the storage, and is not overwritten by the batch.
+	storage.fail = false
+	suite.True(pref.Edit().Put("key1", 4).Commit())
+	suite.Empty(suite.journals())
+	suite.Equal(load(PrefName).values(), map[string]interface{}{"key1": 4, "key2": 2})
+	suite.Equal(load(OtherPrefName).values(), map[string]interface{}{"key3": 3})
+}
+
+func (suite *BatchTestSuite) TestRecoverFailedBatch() {
+	storage := &failingStorage{Storage: newFileStorage(basePath + PrefName), fail: true}
+	WithStorage(storage)(pref)
+	suite.Error(NewBatch().Add(pref.Edit().Put("key1", 1).Put("key2", 2)).Add(suite.other.Edit().Put("key3", 3)).Commit())
+	// The commits which also fail are written to the journal ahead, so it never reverts them.
+	suite.False(pref.Edit().Put("key1", 4).Commit())
+
+	// Simulate a crash, the batch is completed when the Preferences is loaded again.
+	suite.Equal(load(PrefName).values(), map[string]interface{}{"key1": 4, "key2": 2})
+	suite.Equal(load(OtherPrefName).values(), map[string]interface{}{"key3": 3})
+	suite.Empty(suite.journals())
+}
+
+func (suite *BatchTestSuite) TestJournalDir() {
+	p := newPreferencesImpl(PrefName)
+	basePath = "./other/"
+	suite.Nil(NewBatch().Add(p.Edit().Put("key1", 1)).Commit())
+	basePath = "./"
+	suite.Equal(load(PrefName).values(), map[string]interface{}{"key1": 1})
+}
@@ -0,0 +1,273 @@
+package pref
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tagName is the name of the struct tag which binds a field to a key.
+const tagName = "pref"
+
+// boundField is a field of struct bound to a key.
+type boundField struct {
+	key        string
+	def        string
+	hasDefault bool
+	value      reflect.Value
+}
+
+// Load fills the fields of the struct pointed by v with the values of the Preferences. A field is bound to a
+// key by the tag `pref:"key"`, and the tag `pref:"key,default=value"` sets the default value of a key which
+// has not been set and has no registered default. The default must be the last option of the tag, so it
+// may contain commas, which separate the elements of a slice. A nested struct with the tag `pref:"prefix"`
+// binds its fields to the keys under "prefix.", and the fields without the tag are ignored.
+func Load(p Preferences, v interface{}) error {
+	fields, err := bindFields(v)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if value := p.GetOrDefault(f.key); value != nil {
+			if err := assign(f.value, value); err != nil {
+				return fmt.Errorf("pref: cannot load key %s: %v", f.key, err)
+			}
+		} else if f.hasDefault {
+			value, err := parseDefault(f.def, f.value.Type())
+			if err != nil {
+				return fmt.Errorf("pref: invalid default of key %s: %v", f.key, err)
+			}
+			f.value.Set(value)
+		}
+	}
+	return nil
+}
+
+// Save puts the values of the tagged fields of struct v to the editor, the changes are not committed. See
+// Load for the tags.
+func Save(editor Editor, v interface{}) error {
+	fields, err := bindFields(v)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		editor.Put(f.key, f.value.Interface())
+	}
+	return nil
+}
+
+// Watch loads the struct pointed by v, and loads it again whenever the Preferences changes any key bound to
+// it, until the returned stop function is called. The struct is written while holding mu if it is not nil,
+// and onChange is called after every reload if it is not nil. The listener is registered before the first
+// load, so no change committed in between is missed.
+func Watch(p Preferences, v interface{}, mu sync.Locker, onChange func()) (stop func(), err error) {
+	fields, err := bindFields(v)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool)
+	for _, f := range fields {
+		keys[f.key] = true
+	}
+	reload := func() error {
+		if mu != nil {
+			mu.Lock()
+			defer mu.Unlock()
+		}
+		return Load(p, v)
+	}
+	ch := make(chan string, 16)
+	done := make(chan bool)
+	p.RegisterOnPreferenceChangeListener(ch)
+	if err := reload(); err != nil {
+		p.UnregisterOnPreferenceChangeListener(ch)
+		return nil, err
+	}
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case key := <-ch:
+				if !keys[key] {
+					continue
+				}
+				if err := reload(); err != nil {
+					log.Printf("Error when reload the bound struct: %v", err)
+				} else if onChange != nil {
+					onChange()
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.UnregisterOnPreferenceChangeListener(ch)
+			close(done)
+		})
+	}, nil
+}
+
+// bindFields returns the tagged fields of the struct pointed by v.
+func bindFields(v interface{}) ([]boundField, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("pref: expected a pointer to struct but got %T", v)
+	}
+	return appendFields(nil, rv.Elem(), ""), nil
+}
+
+func appendFields(fields []boundField, rv reflect.Value, prefix string) []boundField {
+	for i := 0; i < rv.NumField(); i++ {
+		sf := rv.Type().Field(i)
+		tag, tagged := sf.Tag.Lookup(tagName)
+		if sf.PkgPath != "" || tag == "-" {
+			continue
+		}
+		if !tagged {
+			// The fields of an embedded struct are bound as if they were declared by the outer one.
+			if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+				fields = appendFields(fields, rv.Field(i), prefix)
+			}
+			continue
+		}
+		f := boundField{value: rv.Field(i)}
+		f.key = tag
+		if n := strings.Index(tag, ","); n >= 0 {
+			f.key = tag[:n]
+			if options := tag[n+1:]; strings.HasPrefix(options, "default=") {
+				f.def = strings.TrimPrefix(options, "default=")
+				f.hasDefault = true
+			}
+		}
+		f.key = prefix + f.key
+		if sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(time.Time{}) {
+			fields = appendFields(fields, f.value, f.key+".")
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// assign sets the value to the field, a number is converted to the numeric type of the field if it can be
+// represented by the type, and the elements of a slice are converted one by one.
+func assign(field reflect.Value, value interface{}) error {
+	rv := reflect.ValueOf(value)
+	if rv.Type().AssignableTo(field.Type()) {
+		field.Set(rv)
+		return nil
+	}
+	if isNumber(rv.Kind()) && isNumber(field.Kind()) {
+		converted, err := convertNumber(rv, field.Type())
+		if err != nil {
+			return err
+		}
+		field.Set(converted)
+		return nil
+	}
+	if rv.Kind() == reflect.Slice && field.Kind() == reflect.Slice {
+		slice := reflect.MakeSlice(field.Type(), rv.Len(), rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			if err := assign(slice.Index(i), rv.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	return fmt.Errorf("cannot assign %T to %v", value, field.Type())
+}
+
+// convertNumber converts a number to the numeric type. An integer must be represented exactly, while a
+// float converted to a float type may be rounded, but must not overflow.
+func convertNumber(rv reflect.Value, typ reflect.Type) (reflect.Value, error) {
+	converted := rv.Convert(typ)
+	if isFloat(rv.Kind()) && isFloat(typ.Kind()) {
+		if math.IsInf(converted.Float(), 0) && !math.IsInf(rv.Float(), 0) {
+			return reflect.Value{}, fmt.Errorf("%v overflows %v", rv.Interface(), typ)
+		}
+		return converted, nil
+	}
+	if converted.Convert(rv.Type()).Interface() != rv.Interface() || isNegative(converted) != isNegative(rv) {
+		return reflect.Value{}, fmt.Errorf("cannot convert %v to %v exactly", rv.Interface(), typ)
+	}
+	return converted, nil
+}
+
+func isFloat(kind reflect.Kind) bool {
+	return kind == reflect.Float32 || kind == reflect.Float64
+}
+
+func isNegative(rv reflect.Value) bool {
+	switch {
+	case rv.Kind() >= reflect.Int && rv.Kind() <= reflect.Int64:
+		return rv.Int() < 0
+	case isFloat(rv.Kind()):
+		return rv.Float() < 0
+	}
+	return false
+}
+
+func isNumber(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// parseDefault parses the default value in a tag into the type of field, the elements of a slice are
+// separated by commas.
+func parseDefault(text string, typ reflect.Type) (reflect.Value, error) {
+	v := reflect.New(typ).Elem()
+	var err error
+	switch {
+	case typ == reflect.TypeOf(time.Duration(0)):
+		var d time.Duration
+		d, err = time.ParseDuration(text)
+		v.SetInt(int64(d))
+	case typ.Kind() == reflect.String:
+		v.SetString(text)
+	case typ.Kind() == reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(text)
+		v.SetBool(b)
+	case typ.Kind() >= reflect.Int && typ.Kind() <= reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(text, 10, typ.Bits())
+		v.SetInt(i)
+	case typ.Kind() >= reflect.Uint && typ.Kind() <= reflect.Uint64:
+		var u uint64
+		u, err = strconv.ParseUint(text, 10, typ.Bits())
+		v.SetUint(u)
+	case typ.Kind() == reflect.Float32 || typ.Kind() == reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(text, typ.Bits())
+		v.SetFloat(f)
+	case typ.Kind() == reflect.Slice:
+		var elems []string
+		if text != "" {
+			elems = strings.Split(text, ",")
+		}
+		v.Set(reflect.MakeSlice(typ, len(elems), len(elems)))
+		for i, elem := range elems {
+			var e reflect.Value
+			if e, err = parseDefault(strings.TrimSpace(elem), typ.Elem()); err != nil {
+				break
+			}
+			v.Index(i).Set(e)
+		}
+	default:
+		err = fmt.Errorf("unsupported type %v", typ)
+	}
+	return v, err
+}
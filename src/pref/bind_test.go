@@ -0,0 +1,169 @@
+package pref
+
+import (
+	"encoding/gob"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	gob.Register([]string{})
+	gob.Register(time.Duration(0))
+}
+
+type NetworkConfig struct {
+	Timeout time.Duration `pref:"timeout,default=30s"`
+	Retry   int           `pref:"retry,default=3"`
+	Hosts   []string      `pref:"hosts,default=a.example.com,b.example.com"`
+}
+
+type Common struct {
+	Debug bool `pref:"debug"`
+}
+
+type Config struct {
+	Common
+	Name     string        `pref:"name,default=pref"`
+	Ratio    float64       `pref:"ratio"`
+	Network  NetworkConfig `pref:"network"`
+	Ignored  string
+	Excluded string `pref:"-"`
+}
+
+type BindTestSuite struct {
+	suite.Suite
+}
+
+func (suite *BindTestSuite) SetupTest() {
+	basePath = "./"
+	pref = newPreferencesImpl(PrefName)
+}
+
+func (suite *BindTestSuite) TearDownTest() {
+	os.Remove(basePath + PrefName)
+	os.Remove(basePath + PrefName + "_bak")
+}
+
+func TestBindTestSuite(t *testing.T) {
+	suite.Run(t, new(BindTestSuite))
+}
+
+func (suite *BindTestSuite) TestLoadDefaults() {
+	var cfg Config
+	suite.Nil(Load(pref, &cfg))
+	suite.Equal(cfg, Config{
+		Name: "pref",
+		Network: NetworkConfig{
+			Timeout: 30 * time.Second,
+			Retry:   3,
+			Hosts:   []string{"a.example.com", "b.example.com"},
+		},
+	})
+}
+
+func (suite *BindTestSuite) TestLoad() {
+	pref.SetDefaults(map[string]interface{}{"network.retry": 5})
+	pref.Edit().Put("debug", true).Put("ratio", 0.5).Put("network.timeout", int64(time.Second)).
+		Put("network.hosts", []string{"c.example.com"}).Put("Ignored", "value").Commit()
+	var cfg Config
+	suite.Nil(Load(pref, &cfg))
+	suite.True(cfg.Debug)
+	suite.Equal(cfg.Ratio, 0.5)
+	suite.Equal(cfg.Network.Timeout, time.Second)
+	suite.Equal(cfg.Network.Retry, 5)
+	suite.Equal(cfg.Network.Hosts, []string{"c.example.com"})
+	suite.Equal(cfg.Ignored, "")
+}
+
+func (suite *BindTestSuite) TestLoadError() {
+	pref.Edit().Put("network.retry", "3").Commit()
+	var cfg Config
+	suite.Error(Load(pref, &cfg))
+	suite.Error(Load(pref, cfg))
+}
+
+func (suite *BindTestSuite) TestLoadConversion() {
+	var counts struct {
+		Small  int8    `pref:"small"`
+		Count  uint32  `pref:"count"`
+		Ratio  float32 `pref:"ratio"`
+		Amount int     `pref:"amount"`
+	}
+	pref.Edit().Put("small", int64(100)).Put("count", 7).Put("ratio", 0.5).Put("amount", 3.0).Commit()
+	suite.Nil(Load(pref, &counts))
+	suite.Equal(int8(100), counts.Small)
+	suite.Equal(uint32(7), counts.Count)
+	suite.Equal(float32(0.5), counts.Ratio)
+	suite.Equal(3, counts.Amount)
+
+	for key, value := range map[string]interface{}{"small": 300, "count": -1, "ratio": 1e300, "amount": 2.5} {
+		pref.Edit().Clear().Put(key, value).Commit()
+		suite.Error(Load(pref, &counts), key)
+	}
+}
+
+func (suite *BindTestSuite) TestSave() {
+	cfg := Config{Name: "saved", Network: NetworkConfig{Retry: 2, Hosts: []string{"host"}}, Ignored: "value"}
+	e := pref.Edit()
+	suite.Nil(Save(e, &cfg))
+	suite.True(e.Commit())
+	suite.Equal(pref.GetString("name", ""), "saved")
+	suite.Equal(pref.GetInt("network.retry", 0), 2)
+	suite.Equal(pref.GetObject("network.hosts", nil), []string{"host"})
+	suite.False(pref.Contains("Ignored"))
+
+	var loaded Config
+	suite.Nil(Load(pref, &loaded))
+	loaded.Ignored = "value"
+	suite.Equal(loaded, cfg)
+}
+
+func (suite *BindTestSuite) TestWatch() {
+	var cfg Config
+	var mu sync.Mutex
+	changed := make(chan bool, 1)
+	stop, err := Watch(pref, &cfg, &mu, func() {
+		changed <- true
+	})
+	suite.Nil(err)
+	defer stop()
+	pref.Edit().Put("other", 1).Put("network.retry", 7).Commit()
+	<-changed
+	mu.Lock()
+	suite.Equal(cfg.Network.Retry, 7)
+	mu.Unlock()
+}
+
+// racingPreferences commits a change of a key right after it is read by the first load of Watch.
+type racingPreferences struct {
+	Preferences
+	once sync.Once
+}
+
+func (p *racingPreferences) GetOrDefault(key string) interface{} {
+	v := p.Preferences.GetOrDefault(key)
+	if key == "network.retry" {
+		p.once.Do(func() {
+			p.Preferences.Edit().Put(key, 9).Commit()
+		})
+	}
+	return v
+}
+
+func (suite *BindTestSuite) TestWatchChangeDuringLoad() {
+	var cfg Config
+	var mu sync.Mutex
+	changed := make(chan bool, 1)
+	stop, err := Watch(&racingPreferences{Preferences: pref}, &cfg, &mu, func() {
+		changed <- true
+	})
+	suite.Nil(err)
+	defer stop()
+	<-changed
+	mu.Lock()
+	suite.Equal(cfg.Network.Retry, 9)
+	mu.Unlock()
+}
@@ -0,0 +1,96 @@
+package pref
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+)
+
+// SetDefaults registers the default values of keys, which are returned by the getters when the keys have
+// not been set, instead of the default values passed to the getters. The defaults are merged into the
+// ones registered before, and a nil value unregisters the default of a key.
+func (p *PreferencesImpl) SetDefaults(defaults map[string]interface{}) {
+	p.Lock()
+	defer p.Unlock()
+	merged := make(map[string]interface{})
+	for k, v := range p.defaults.Load().(map[string]interface{}) {
+		merged[k] = v
+	}
+	for k, v := range defaults {
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	p.defaults.Store(merged)
+}
+
+// LoadDefaults registers the default values of a Preferences from a JSON object, such as a defaults file
+// embedded in the program. A JSON number is loaded as an int if it is integral, otherwise as a float64.
+func LoadDefaults(p Preferences, r io.Reader) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var defaults map[string]interface{}
+	if err := dec.Decode(&defaults); err != nil {
+		return err
+	}
+	for k, v := range defaults {
+		defaults[k] = fromJSON(v)
+	}
+	p.SetDefaults(defaults)
+	return nil
+}
+
+// GetOrDefault returns the value of a key, or its registered default value if the key has not been set.
+// It returns nil if neither exists.
+func (p *PreferencesImpl) GetOrDefault(key string) interface{} {
+	return p.GetObject(key, nil)
+}
+
+// IsDefault returns whether the value of a key is its registered default value, either because the key has
+// not been set or because it has been set to the same value.
+func (p *PreferencesImpl) IsDefault(key string) bool {
+	p.loadWg.Wait()
+	v, exist := p.lookup(p.values(), key)
+	if !exist {
+		return true
+	}
+	registered, exist := p.defaultValue(key)
+	return exist && reflect.DeepEqual(v, registered)
+}
+
+// defaultValue returns the default value registered by SetDefaults, or declared in the schema.
+func (p *PreferencesImpl) defaultValue(key string) (interface{}, bool) {
+	if v, exist := p.defaults.Load().(map[string]interface{})[key]; exist {
+		return v, true
+	}
+	if schema := p.schema.Load().(*Schema); schema != nil {
+		if spec, exist := schema.Spec(key); exist && spec.Default != nil {
+			return spec.Default, true
+		}
+	}
+	return nil, false
+}
+
+// fromJSON converts the json.Number in a value decoded by json.Decoder.UseNumber to an int if it is
+// integral, otherwise to a float64.
+func fromJSON(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil && int64(int(i)) == i {
+			return int(i)
+		}
+		f, _ := val.Float64()
+		return f
+	case []interface{}:
+		for i := range val {
+			val[i] = fromJSON(val[i])
+		}
+	case map[string]interface{}:
+		for k := range val {
+			val[k] = fromJSON(val[k])
+		}
+	}
+	return v
+}
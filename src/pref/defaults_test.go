@@ -0,0 +1,81 @@
+package pref
+
+import (
+	"github.com/stretchr/testify/suite"
+	"os"
+	"strings"
+	"testing"
+)
+
+type DefaultsTestSuite struct {
+	suite.Suite
+}
+
+func (suite *DefaultsTestSuite) SetupTest() {
+	basePath = "./"
+	pref = newPreferencesImpl(PrefName)
+}
+
+func (suite *DefaultsTestSuite) TearDownTest() {
+	os.Remove(basePath + PrefName)
+	os.Remove(basePath + PrefName + "_bak")
+}
+
+func TestDefaultsTestSuite(t *testing.T) {
+	suite.Run(t, new(DefaultsTestSuite))
+}
+
+func (suite *DefaultsTestSuite) TestSetDefaults() {
+	pref.SetDefaults(map[string]interface{}{"timeout": 30, "name": "pref"})
+	suite.Equal(pref.GetInt("timeout", 10), 30)
+	suite.Equal(pref.GetString("timeout", "10s"), "10s")
+	suite.Equal(pref.GetOrDefault("name"), "pref")
+	suite.Nil(pref.GetOrDefault("other"))
+	suite.False(pref.Contains("timeout"))
+
+	pref.Edit().Put("timeout", 20).Commit()
+	suite.Equal(pref.GetInt("timeout", 10), 20)
+	suite.Equal(pref.GetOrDefault("timeout"), 20)
+
+	pref.SetDefaults(map[string]interface{}{"name": nil, "retry": 3})
+	suite.Nil(pref.GetOrDefault("name"))
+	suite.Equal(pref.GetOrDefault("retry"), 3)
+	suite.Equal(pref.GetInt("timeout", 0), 20)
+}
+
+func (suite *DefaultsTestSuite) TestIsDefault() {
+	pref.SetDefaults(map[string]interface{}{"timeout": 30})
+	suite.True(pref.IsDefault("timeout"))
+	suite.True(pref.IsDefault("other"))
+	pref.Edit().Put("timeout", 20).Put("other", 1).Commit()
+	suite.False(pref.IsDefault("timeout"))
+	suite.False(pref.IsDefault("other"))
+	pref.Edit().Put("timeout", 30).Commit()
+	suite.True(pref.IsDefault("timeout"))
+}
+
+func (suite *DefaultsTestSuite) TestLoadDefaults() {
+	err := LoadDefaults(pref, strings.NewReader(`{"timeout": 30, "ratio": 0.5, "name": "pref", "debug": true}`))
+	suite.Nil(err)
+	suite.Equal(pref.GetInt("timeout", 0), 30)
+	suite.Equal(pref.GetFloat64("ratio", 0), 0.5)
+	suite.Equal(pref.GetString("name", ""), "pref")
+	suite.Equal(pref.GetBool("debug", false), true)
+	suite.Error(LoadDefaults(pref, strings.NewReader(`[1]`)))
+}
+
+func (suite *DefaultsTestSuite) TestSchemaDefaults() {
+	pref.SetSchema(NewSchema().Define("timeout", KeySpec{Default: 30}))
+	suite.Equal(pref.GetInt("timeout", 10), 30)
+	pref.SetDefaults(map[string]interface{}{"timeout": 40})
+	suite.Equal(pref.GetInt("timeout", 10), 40)
+}
+
+func (suite *DefaultsTestSuite) TestTx() {
+	pref.SetDefaults(map[string]interface{}{"count": 5})
+	pref.Update(func(tx Tx) error {
+		tx.Put("count", tx.GetInt("count", 0)+1)
+		return nil
+	})
+	suite.Equal(pref.GetInt("count", 0), 6)
+}
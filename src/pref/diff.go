@@ -0,0 +1,88 @@
+package pref
+
+import (
+	"reflect"
+)
+
+// ChangeKind is the kind of a Change.
+type ChangeKind int
+
+const (
+	// Added is a key which only exists in the new key-values.
+	Added ChangeKind = iota
+	// Removed is a key which only exists in the old key-values.
+	Removed
+	// Modified is a key whose value is different between the old and the new key-values.
+	Modified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	}
+	return "unknown"
+}
+
+// Change is the difference of a key between two versions of key-values, Old is nil if the key is added and
+// New is nil if the key is removed.
+type Change struct {
+	Key  string
+	Kind ChangeKind
+	Old  interface{}
+	New  interface{}
+}
+
+// Diff returns the changes from the key-values of a to the ones of b, sorted by key.
+func Diff(a, b Preferences) []Change {
+	return diffValues(a.GetAll(), b.GetAll())
+}
+
+func diffValues(a, b map[string]interface{}) []Change {
+	keys := make(map[string]interface{}, len(a)+len(b))
+	for k := range a {
+		keys[k] = nil
+	}
+	for k := range b {
+		keys[k] = nil
+	}
+	changes := make([]Change, 0)
+	for _, k := range sortedKeys(keys) {
+		if change, changed := changeOf(k, a[k], b[k]); changed {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// changeOf returns the change of key from old to new, a nil value means the key does not exist.
+func changeOf(key string, old, new interface{}) (Change, bool) {
+	switch {
+	case old == nil && new == nil:
+		return Change{}, false
+	case old == nil:
+		return Change{Key: key, Kind: Added, New: new}, true
+	case new == nil:
+		return Change{Key: key, Kind: Removed, Old: old}, true
+	case !reflect.DeepEqual(old, new):
+		return Change{Key: key, Kind: Modified, Old: old, New: new}, true
+	}
+	return Change{}, false
+}
+
+// ApplyChanges puts the new values of the changes to the editor, and removes the keys removed by them. The
+// changes are not committed.
+func ApplyChanges(editor Editor, changes []Change) Editor {
+	for _, c := range changes {
+		if c.Kind == Removed {
+			editor.Remove(c.Key)
+		} else {
+			editor.Put(c.Key, c.New)
+		}
+	}
+	return editor
+}
@@ -0,0 +1,45 @@
+package pref
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type DiffTestSuite struct {
+	suite.Suite
+}
+
+func TestDiffTestSuite(t *testing.T) {
+	suite.Run(t, new(DiffTestSuite))
+}
+
+// memPreferences creates a Preferences with the key-values which are not stored.
+func memPreferences(name string, m map[string]interface{}) *PreferencesImpl {
+	p := newPreferencesImpl(name)
+	p.publishLocked(m)
+	return p
+}
+
+func (suite *DiffTestSuite) TestDiff() {
+	a := memPreferences("a", map[string]interface{}{"same": 1, "modified": "x", "removed": true, versionKey: 1})
+	b := memPreferences("b", map[string]interface{}{"same": 1, "modified": "y", "added": []string{"z"}})
+	suite.Equal([]Change{
+		{Key: "added", Kind: Added, New: []string{"z"}},
+		{Key: "modified", Kind: Modified, Old: "x", New: "y"},
+		{Key: "removed", Kind: Removed, Old: true},
+	}, Diff(a, b))
+	suite.Empty(Diff(a, a))
+	suite.Equal("modified", Modified.String())
+}
+
+func (suite *DiffTestSuite) TestApplyChanges() {
+	a := memPreferences("a", map[string]interface{}{"same": 1, "modified": "x", "removed": true})
+	b := memPreferences("b", map[string]interface{}{"same": 1, "modified": "y", "added": 2})
+	editor := newEditorImpl(a)
+	ApplyChanges(editor, Diff(a, b))
+	editor.Lock()
+	keys, _ := editor.commitToMemoryLocked()
+	editor.Unlock()
+	suite.Len(keys, 3)
+	suite.Equal(b.GetAll(), a.GetAll())
+}
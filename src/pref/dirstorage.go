@@ -0,0 +1,241 @@
+package pref
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// typeSuffix is the suffix of the sidecar file which marks the type of a key.
+const typeSuffix = ".type"
+
+var (
+	// dirCodecs are the codecs registered by registerDirCodec by their type names.
+	dirCodecs = make(map[string]dirCodec)
+	// dirCodecNames are the type names of the registered codecs by their types.
+	dirCodecNames = make(map[reflect.Type]string)
+)
+
+// dirCodec formats the values of a type which is not supported by FormatValue as the text of a file, such
+// as the values wrapped by the Preferences itself.
+type dirCodec struct {
+	format func(v interface{}) (string, error)
+	parse  func(text string) (interface{}, error)
+}
+
+// registerDirCodec registers the codec of the type of sample, the values of the type are marked by name in
+// their sidecar files. It must be called in init.
+func registerDirCodec(name string, sample interface{}, codec dirCodec) {
+	dirCodecs[name] = codec
+	dirCodecNames[reflect.TypeOf(sample)] = name
+}
+
+// formatDirValue formats a value as the text of a file, by its registered codec or by FormatValue.
+func formatDirValue(v interface{}) (typeName string, text string, err error) {
+	if name, exist := dirCodecNames[reflect.TypeOf(v)]; exist {
+		text, err := dirCodecs[name].format(v)
+		return name, text, err
+	}
+	return FormatValue(v)
+}
+
+// parseDirValue parses the text of a file formatted by formatDirValue.
+func parseDirValue(typeName string, text string) (interface{}, error) {
+	if codec, exist := dirCodecs[typeName]; exist {
+		return codec.parse(text)
+	}
+	return ParseValue(typeName, text)
+}
+
+// dirStorage stores every key as a file in a directory, such as one delivered by config management or a
+// mounted Kubernetes ConfigMap. The file contains the value as text, and its type is read from a hidden
+// sidecar file ".<key>.type", a key without the sidecar is a string. Other hidden files are ignored, which
+// also skips the "..data" entries of a ConfigMap mount. The file names are the keys escaped by escapeKey.
+type dirStorage struct {
+	dir string
+	// fingerprint is the names, sizes and modification times of the files when they were last accessed.
+	fingerprint string
+}
+
+func newDirStorage(dir string) *dirStorage {
+	return &dirStorage{dir: dir}
+}
+
+// Load reads all the keys from the files in the directory.
+func (s *dirStorage) Load() (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	infos, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		s.fingerprint = ""
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		key, err := url.PathUnescape(info.Name())
+		if err != nil || key == "" {
+			continue
+		}
+		// Follow the symbolic links of a ConfigMap mount.
+		path := filepath.Join(s.dir, info.Name())
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+		// A file which cannot be read or parsed only loses its own key.
+		v, err := s.read(key, path)
+		if err != nil {
+			log.Printf("Error when load the key %s from %s: %v", key, s.dir, err)
+			continue
+		}
+		m[key] = v
+	}
+	s.fingerprint = s.scan()
+	return m, nil
+}
+
+// read reads the value of key from the file at path and its type from the sidecar file.
+func (s *dirStorage) read(key, path string) (interface{}, error) {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	typeName, err := ioutil.ReadFile(s.typePath(key))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	v, err := parseDirValue(string(bytes.TrimSpace(typeName)), string(text))
+	if err != nil {
+		return nil, fmt.Errorf("pref: invalid value of key %s: %v", key, err)
+	}
+	return v, nil
+}
+
+// Save writes the files of the changed keys, only the values of the types supported by the typed getters
+// and the types registered by registerDirCodec can be stored.
+func (s *dirStorage) Save(m map[string]interface{}, records []Record) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	defer func() {
+		s.fingerprint = s.scan()
+	}()
+	for _, r := range records {
+		if r.Op != OpClear && r.Key == "" {
+			return errors.New("pref: the dir storage cannot store an empty key")
+		}
+		switch r.Op {
+		case OpPut:
+			typeName, text, err := formatDirValue(r.Value)
+			if err != nil {
+				return err
+			}
+			if typeName == "string" {
+				err = removeFile(s.typePath(r.Key))
+			} else {
+				err = writeFile(s.typePath(r.Key), []byte(typeName))
+			}
+			if err != nil {
+				return err
+			}
+			if err := writeFile(s.valuePath(r.Key), []byte(text)); err != nil {
+				return err
+			}
+		case OpRemove:
+			if err := s.remove(r.Key); err != nil {
+				return err
+			}
+		case OpClear:
+			infos, err := ioutil.ReadDir(s.dir)
+			if err != nil {
+				return err
+			}
+			for _, info := range infos {
+				if strings.HasPrefix(info.Name(), ".") {
+					continue
+				}
+				if key, err := url.PathUnescape(info.Name()); err == nil {
+					if err := s.remove(key); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Changed returns whether the files have been modified since they were last loaded or saved.
+func (s *dirStorage) Changed() bool {
+	return s.scan() != s.fingerprint
+}
+
+func (s *dirStorage) remove(key string) error {
+	if err := removeFile(s.valuePath(key)); err != nil {
+		return err
+	}
+	return removeFile(s.typePath(key))
+}
+
+func (s *dirStorage) valuePath(key string) string {
+	return filepath.Join(s.dir, escapeKey(key))
+}
+
+func (s *dirStorage) typePath(key string) string {
+	return filepath.Join(s.dir, "."+escapeKey(key)+typeSuffix)
+}
+
+// escapeKey escapes key as a file name. A leading dot is escaped too, so the file of a key is never hidden,
+// and the keys "." and ".." never resolve to the directory or its parent.
+func escapeKey(key string) string {
+	name := url.PathEscape(key)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return name
+}
+
+func (s *dirStorage) scan() string {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return ""
+	}
+	lines := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info, err := os.Stat(filepath.Join(s.dir, info.Name())); err == nil {
+			lines = append(lines, fmt.Sprintf("%s %d %d", info.Name(), info.Size(), info.ModTime().UnixNano()))
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// writeFile replaces the file with data atomically, so a reader never sees a partially written value.
+func writeFile(path string, data []byte) error {
+	tmpPath := filepath.Join(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package pref
+
+import (
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+type DirStorageTestSuite struct {
+	suite.Suite
+}
+
+func (suite *DirStorageTestSuite) SetupTest() {
+	basePath = "./"
+	pref = newPreferencesImpl(PrefName)
+	WithDirStorage()(pref)
+}
+
+func (suite *DirStorageTestSuite) TearDownTest() {
+	os.RemoveAll(basePath + PrefName)
+}
+
+func TestDirStorageTestSuite(t *testing.T) {
+	suite.Run(t, new(DirStorageTestSuite))
+}
+
+func (suite *DirStorageTestSuite) reload() {
+	pref = newPreferencesImpl(PrefName)
+	WithDirStorage()(pref)
+	pref.loadWg.Add(1)
+	go pref.loadFromFile()
+	pref.loadWg.Wait()
+}
+
+func (suite *DirStorageTestSuite) TestReadWrite() {
+	pref.Edit().Put("int", 3).Put("float", 2.5).Put("bool", true).Put("string", "value").Put("a/b", int64(7)).Commit()
+	data, err := ioutil.ReadFile(basePath + PrefName + "/int")
+	suite.Nil(err)
+	suite.Equal(string(data), "3")
+	data, err = ioutil.ReadFile(basePath + PrefName + "/.int.type")
+	suite.Nil(err)
+	suite.Equal(string(data), "int")
+	_, err = os.Stat(basePath + PrefName + "/.string.type")
+	suite.True(os.IsNotExist(err))
+
+	suite.reload()
+	suite.Len(pref.values(), 5)
+	suite.Equal(pref.GetInt("int", 0), 3)
+	suite.Equal(pref.GetFloat64("float", 0), 2.5)
+	suite.Equal(pref.GetBool("bool", false), true)
+	suite.Equal(pref.GetString("string", ""), "value")
+	suite.Equal(pref.GetInt64("a/b", 0), int64(7))
+}
+
+func (suite *DirStorageTestSuite) TestDotKeys() {
+	suite.True(pref.Edit().Put(".hidden", 1).Put(".", 2).Put("..", 3).Put("a.b", 4).Commit())
+	_, err := os.Stat(basePath + PrefName + "/%2Ehidden")
+	suite.Nil(err)
+
+	suite.reload()
+	suite.Equal(map[string]interface{}{".hidden": 1, ".": 2, "..": 3, "a.b": 4}, pref.values())
+	suite.True(pref.Edit().Remove(".").Remove("..").Commit())
+	suite.reload()
+	suite.Equal(map[string]interface{}{".hidden": 1, "a.b": 4}, pref.values())
+}
+
+func (suite *DirStorageTestSuite) TestEmptyKey() {
+	suite.False(pref.Edit().Put("", 1).Commit())
+}
+
+func (suite *DirStorageTestSuite) TestRemoveAndClear() {
+	pref.Edit().Put("key1", 1).Put("key2", 2).Commit()
+	pref.Edit().Remove("key1").Commit()
+	_, err := os.Stat(basePath + PrefName + "/key1")
+	suite.True(os.IsNotExist(err))
+	_, err = os.Stat(basePath + PrefName + "/.key1.type")
+	suite.True(os.IsNotExist(err))
+	pref.Edit().Clear().Put("key3", "value").Commit()
+	suite.reload()
+	suite.Len(pref.values(), 1)
+	suite.Equal(pref.GetString("key3", ""), "value")
+}
+
+func (suite *DirStorageTestSuite) TestSkipInvalidFile() {
+	pref.Edit().Put("good", 1).Put("bad", 2).Commit()
+	suite.Nil(ioutil.WriteFile(basePath+PrefName+"/bad", []byte("abc"), 0644))
+
+	suite.reload()
+	suite.Equal(map[string]interface{}{"good": 1}, pref.values())
+}
+
+func (suite *DirStorageTestSuite) TestUnsupportedType() {
+	suite.False(pref.Edit().Put("key", struct{ Field string }{"value"}).Commit())
+}
+
+func (suite *DirStorageTestSuite) TestReload() {
+	pref.Edit().Put("key1", "value1").Put("key2", "value2").Commit()
+	suite.False(pref.storageChanged())
+	ch := make(chan string, 2)
+	pref.RegisterOnPreferenceChangeListener(ch)
+	ioutil.WriteFile(basePath+PrefName+"/key1", []byte("changed"), 0644)
+	ioutil.WriteFile(basePath+PrefName+"/key3", []byte("new"), 0644)
+	suite.True(pref.storageChanged())
+	suite.True(pref.Reload())
+	s := map[string]interface{}{<-ch: nil, <-ch: nil}
+	suite.Contains(s, "key1")
+	suite.Contains(s, "key3")
+	suite.Equal(pref.GetString("key1", ""), "changed")
+	suite.Equal(pref.GetString("key3", ""), "new")
+	suite.False(pref.storageChanged())
+}
+
+func (suite *DirStorageTestSuite) TestWatch() {
+	pref.Edit().Put("key", "value").Commit()
+	ch := make(chan string, 1)
+	pref.RegisterOnPreferenceChangeListener(ch)
+	stop := pref.Watch(10 * time.Millisecond)
+	defer stop()
+	os.Remove(basePath + PrefName + "/key")
+	suite.Equal(<-ch, "key")
+	suite.False(pref.Contains("key"))
+}
@@ -0,0 +1,33 @@
+package pref
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrConflict is the error of a commit by an editor from EditAt, when the Preferences has been changed
+// since the revision of the editor.
+var ErrConflict = errors.New("pref: preference has been changed since the revision")
+
+// ConditionError is the error of a commit whose conditions set by Editor.CompareAndPut or
+// Editor.Increment failed.
+type ConditionError struct {
+	// Keys are the keys whose conditions failed, in sorted order.
+	Keys []string
+}
+
+func (e *ConditionError) Error() string {
+	return fmt.Sprintf("pref: conditions failed for keys %s", strings.Join(e.Keys, ", "))
+}
+
+// ValidationError is the error of a value which does not match the declaration of its key in a Schema.
+type ValidationError struct {
+	Key    string
+	Value  interface{}
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("pref: invalid value %v of key %s: %s", e.Value, e.Key, e.Reason)
+}
@@ -0,0 +1,225 @@
+package pref
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format is a file format which the key-values are exported to and imported from.
+type Format string
+
+const (
+	// FormatJSON is a JSON object, the numbers are imported as int if they are integral, otherwise float64.
+	FormatJSON Format = "json"
+	// FormatYAML is a flat YAML mapping, the scalars and the flow sequences of scalars are supported.
+	FormatYAML Format = "yaml"
+	// FormatTOML is a flat TOML document, the keys in a table are imported with the table name as prefix.
+	FormatTOML Format = "toml"
+	// FormatXML is the XML file of Android's SharedPreferences.
+	FormatXML Format = "xml"
+	// FormatGob is the gob encoding of the values, it is the only format which keeps every type.
+	FormatGob Format = "gob"
+)
+
+var (
+	// bareYAMLKey and bareTOMLKey match the keys which do not need to be quoted.
+	bareYAMLKey = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	bareTOMLKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// Export writes all the key-values of the Preferences to w in the format. The text formats can only keep
+// the values of the types supported by the typed getters and the slices of them, the values of other types
+// are written as strings formatted by fmt, or as JSON objects in JSON. The integer and float types are not
+// kept in the text formats, use FormatGob to keep every value as it is.
+func Export(p Preferences, w io.Writer, format Format) error {
+	return exportValues(w, p.GetAll(), format)
+}
+
+func exportValues(w io.Writer, m map[string]interface{}, format Format) error {
+	switch format {
+	case FormatJSON:
+		return exportJSON(w, m)
+	case FormatYAML:
+		return exportLines(w, m, func(k string, v interface{}) string {
+			if !bareYAMLKey.MatchString(k) {
+				k = quoteString(k)
+			}
+			return k + ": " + formatText(v)
+		})
+	case FormatTOML:
+		return exportLines(w, m, func(k string, v interface{}) string {
+			if !bareTOMLKey.MatchString(k) {
+				k = quoteString(k)
+			}
+			return k + " = " + formatText(v)
+		})
+	case FormatXML:
+		return exportXML(w, m)
+	case FormatGob:
+		values, err := encodeValues(m)
+		if err != nil {
+			return err
+		}
+		return gob.NewEncoder(w).Encode(&fileContent{Values: values})
+	}
+	return fmt.Errorf("pref: unknown format %s", format)
+}
+
+func exportJSON(w io.Writer, m map[string]interface{}) error {
+	values := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if raw, ok := v.(RawValue); ok {
+			values[k] = raw.String()
+		} else {
+			values[k] = v
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(values)
+}
+
+// exportLines writes a line formatted by line for each key in order.
+func exportLines(w io.Writer, m map[string]interface{}, line func(string, interface{}) string) error {
+	for _, k := range sortedKeys(m) {
+		if _, err := io.WriteString(w, line(k, m[k])+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportXML writes the key-values as the XML file of Android's SharedPreferences. Android only has int,
+// long, float, boolean, string and string set, so the other numeric types are written as the nearest one
+// and the values of other types are written as strings.
+func exportXML(w io.Writer, m map[string]interface{}) error {
+	if _, err := io.WriteString(w, "<?xml version='1.0' encoding='utf-8' standalone='yes' ?>\n<map>\n"); err != nil {
+		return err
+	}
+	for _, k := range sortedKeys(m) {
+		if _, err := io.WriteString(w, "    "+xmlElement(k, m[k])+"\n"); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "</map>\n")
+	return err
+}
+
+func xmlElement(key string, v interface{}) string {
+	name := escapeXML(key)
+	var tag string
+	switch val := v.(type) {
+	case bool:
+		tag = "boolean"
+	case int, int32, byte:
+		tag = "int"
+	case int64, uint32, uint64:
+		tag = "long"
+	case float32, float64:
+		tag = "float"
+	case []string:
+		elems := make([]string, len(val))
+		for i, s := range val {
+			elems[i] = "<string>" + escapeXML(s) + "</string>"
+		}
+		return fmt.Sprintf(`<set name="%s">%s</set>`, name, strings.Join(elems, ""))
+	case string:
+		return fmt.Sprintf(`<string name="%s">%s</string>`, name, escapeXML(val))
+	default:
+		return fmt.Sprintf(`<string name="%s">%s</string>`, name, escapeXML(fmt.Sprint(v)))
+	}
+	_, text, _ := FormatValue(v)
+	return fmt.Sprintf(`<%s name="%s" value="%s" />`, tag, name, text)
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// formatText formats a value as a YAML or TOML scalar, or a flow sequence of scalars for a slice. A float
+// always has a decimal point or an exponent, so that it is imported as a float again.
+func formatText(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return quoteString(val)
+	case float32:
+		return formatFloat(float64(val), 32)
+	case float64:
+		return formatFloat(val, 64)
+	case bool, int, int32, int64, uint32, uint64, byte:
+		return fmt.Sprint(val)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+		elems := make([]string, rv.Len())
+		for i := range elems {
+			elems[i] = formatText(rv.Index(i).Interface())
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	}
+	return quoteString(fmt.Sprint(v))
+}
+
+func formatFloat(f float64, bits int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	text := strconv.FormatFloat(f, 'g', -1, bits)
+	if !strings.ContainsAny(text, ".eE") {
+		text += ".0"
+	}
+	return text
+}
+
+// quoteString quotes a string with the escapes shared by JSON, YAML and TOML, which can be unquoted by
+// strconv.Unquote.
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f || r == utf8.RuneError:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
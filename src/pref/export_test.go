@@ -0,0 +1,118 @@
+package pref
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"testing"
+)
+
+type ExportTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ExportTestSuite) SetupTest() {
+	basePath = "./"
+	pref = newPreferencesImpl(PrefName)
+	pref.Edit().
+		Put("count", 3).
+		Put("total", int64(7)).
+		Put("ratio", 0.5).
+		Put("whole", 2.0).
+		Put("enabled", true).
+		Put("name", "a<b \"c\"\n").
+		Put("tags", []string{"x", "y, z"}).
+		Put("app.title", "t").
+		Put("odd key", "z").
+		Commit()
+}
+
+func (suite *ExportTestSuite) TearDownTest() {
+	os.Remove(basePath + PrefName)
+	os.Remove(basePath + PrefName + "_bak")
+}
+
+func TestExportTestSuite(t *testing.T) {
+	suite.Run(t, new(ExportTestSuite))
+}
+
+func (suite *ExportTestSuite) export(format Format) string {
+	var buf bytes.Buffer
+	suite.Nil(Export(pref, &buf, format))
+	return buf.String()
+}
+
+func (suite *ExportTestSuite) TestJSON() {
+	suite.Equal(`{
+  "app.title": "t",
+  "count": 3,
+  "enabled": true,
+  "name": "a<b \"c\"\n",
+  "odd key": "z",
+  "ratio": 0.5,
+  "tags": [
+    "x",
+    "y, z"
+  ],
+  "total": 7,
+  "whole": 2
+}
+`, suite.export(FormatJSON))
+}
+
+func (suite *ExportTestSuite) TestYAML() {
+	suite.Equal(`app.title: "t"
+count: 3
+enabled: true
+name: "a<b \"c\"\n"
+"odd key": "z"
+ratio: 0.5
+tags: ["x", "y, z"]
+total: 7
+whole: 2.0
+`, suite.export(FormatYAML))
+}
+
+func (suite *ExportTestSuite) TestTOML() {
+	suite.Equal(`"app.title" = "t"
+count = 3
+enabled = true
+name = "a<b \"c\"\n"
+"odd key" = "z"
+ratio = 0.5
+tags = ["x", "y, z"]
+total = 7
+whole = 2.0
+`, suite.export(FormatTOML))
+}
+
+func (suite *ExportTestSuite) TestXML() {
+	suite.Equal(`<?xml version='1.0' encoding='utf-8' standalone='yes' ?>
+<map>
+    <string name="app.title">t</string>
+    <int name="count" value="3" />
+    <boolean name="enabled" value="true" />
+    <string name="name">a&lt;b &#34;c&#34;&#xA;</string>
+    <string name="odd key">z</string>
+    <float name="ratio" value="0.5" />
+    <set name="tags"><string>x</string><string>y, z</string></set>
+    <long name="total" value="7" />
+    <float name="whole" value="2" />
+</map>
+`, suite.export(FormatXML))
+}
+
+func (suite *ExportTestSuite) TestExcludeReservedKeys() {
+	pref.Edit().Put(versionKey, 1).Commit()
+	suite.NotContains(suite.export(FormatYAML), "version")
+}
+
+func (suite *ExportTestSuite) TestCustomTypeAsString() {
+	pref.Edit().Put("point", knownPoint{X: 1, Y: 2}).Commit()
+	suite.Contains(suite.export(FormatYAML), `point: "{1 2}"`)
+}
+
+func (suite *ExportTestSuite) TestUnknownFormat() {
+	var buf bytes.Buffer
+	suite.Error(Export(pref, &buf, "csv"))
+}
@@ -0,0 +1,70 @@
+package pref
+
+import "os"
+
+// frameLog is the append-only file of frames of the log storage. The changes of one commit are appended as
+// one frame, so a commit torn by a crash is dropped as a whole when the file is replayed.
+type frameLog struct {
+	path string
+	// size is the size of the valid frames in the file.
+	size int64
+}
+
+// replay passes the data of every valid frame in the file to fn in order. It returns false if the file
+// does not exist.
+func (l *frameLog) replay(fn func(data []byte) error) (bool, error) {
+	file, err := os.OpenFile(l.path, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		l.size = 0
+		return false, nil
+	} else if err != nil {
+		return true, err
+	}
+	defer file.Close()
+	var size int64
+	for {
+		data, err := readFrame(file)
+		if err != nil {
+			break
+		}
+		if err := fn(data); err != nil {
+			return true, err
+		}
+		size += int64(frameHeaderSize + len(data))
+	}
+	// Drop the incomplete frame left by a crash, so the following frames are appended after a valid one.
+	if err := file.Truncate(size); err != nil {
+		return true, err
+	}
+	l.size = size
+	return true, nil
+}
+
+// append writes data as one frame at the end of the file, and syncs the file.
+func (l *frameLog) append(data []byte) error {
+	f := frame(data)
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(f); err != nil {
+		// Cut the partial frame, a later frame appended after it could not be read.
+		file.Truncate(l.size)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	l.size += int64(len(f))
+	return nil
+}
+
+// remove removes the file, a file which does not exist is not an error.
+func (l *frameLog) remove() error {
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l.size = 0
+	return nil
+}
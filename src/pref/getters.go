@@ -0,0 +1,106 @@
+package pref
+
+// TypedGetters implements the typed getters of Reader on top of a GetObject function, so that other
+// implementations of Reader only need to implement Contains and GetObject by themselves.
+type TypedGetters struct {
+	GetObjectFunc func(string, interface{}) interface{}
+}
+
+// GetBool returns the bool value, and return default value if the key has not been set.
+func (g TypedGetters) GetBool(key string, defaultValue bool) bool {
+	val, ok := g.GetObjectFunc(key, defaultValue).(bool)
+	if !ok {
+		return defaultValue
+	}
+	return val
+}
+
+// GetInt returns the int value, and return default value if the key has not been set.
+func (g TypedGetters) GetInt(key string, defaultValue int) int {
+	val, ok := g.GetObjectFunc(key, defaultValue).(int)
+	if !ok {
+		return defaultValue
+	}
+	return val
+}
+
+// GetInt32 returns the int32 value, and return default value if the key has not been set.
+func (g TypedGetters) GetInt32(key string, defaultValue int32) int32 {
+	val, ok := g.GetObjectFunc(key, defaultValue).(int32)
+	if !ok {
+		return defaultValue
+	}
+	return val
+}
+
+// GetInt64 returns the int64 value, and return default value if the key has not been set.
+func (g TypedGetters) GetInt64(key string, defaultValue int64) int64 {
+	val, ok := g.GetObjectFunc(key, defaultValue).(int64)
+	if !ok {
+		return defaultValue
+	}
+	return val
+}
+
+// GetUInt32 returns the uint32 value, and return default value if the key has not been set.
+func (g TypedGetters) GetUInt32(key string, defaultValue uint32) uint32 {
+	val, ok := g.GetObjectFunc(key, defaultValue).(uint32)
+	if !ok {
+		return defaultValue
+	}
+	return val
+}
+
+// GetUInt64 returns the uint64 value, and return default value if the key has not been set.
+func (g TypedGetters) GetUInt64(key string, defaultValue uint64) uint64 {
+	val, ok := g.GetObjectFunc(key, defaultValue).(uint64)
+	if !ok {
+		return defaultValue
+	}
+	return val
+}
+
+// GetFloat32 returns the float32 value, and return default value if the key has not been set.
+func (g TypedGetters) GetFloat32(key string, defaultValue float32) float32 {
+	val, ok := g.GetObjectFunc(key, defaultValue).(float32)
+	if !ok {
+		return defaultValue
+	}
+	return val
+}
+
+// GetFloat64 returns the float64 value, and return default value if the key has not been set.
+func (g TypedGetters) GetFloat64(key string, defaultValue float64) float64 {
+	val, ok := g.GetObjectFunc(key, defaultValue).(float64)
+	if !ok {
+		return defaultValue
+	}
+	return val
+}
+
+// GetByte returns the byte value, and return default value if the key has not been set.
+func (g TypedGetters) GetByte(key string, defaultValue byte) byte {
+	val, ok := g.GetObjectFunc(key, defaultValue).(byte)
+	if !ok {
+		return defaultValue
+	}
+	return val
+}
+
+// GetRune returns the rune value, and return default value if the key has not been set.
+func (g TypedGetters) GetRune(key string, defaultValue rune) rune {
+	val, ok := g.GetObjectFunc(key, defaultValue).(rune)
+	if !ok {
+		return defaultValue
+	}
+	return val
+}
+
+// GetString returns the string value, and return default value if the key has not been set.
+func (g TypedGetters) GetString(key string, defaultValue string) string {
+	val, ok := g.GetObjectFunc(key, defaultValue).(string)
+	if !ok {
+		return defaultValue
+	}
+	return val
+}
@@ -0,0 +1,375 @@
+package pref
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ImportMode decides how the imported key-values are combined with the existing ones.
+type ImportMode int
+
+const (
+	// ImportMerge puts the imported key-values over the existing ones.
+	ImportMerge ImportMode = iota
+	// ImportReplace removes all the existing key-values before putting the imported ones.
+	ImportReplace
+	// ImportSkipExisting only puts the imported keys which do not exist yet.
+	ImportSkipExisting
+)
+
+// errSkipExistingEditor is returned when ImportSkipExisting is used with an editor which cannot read the
+// existing keys.
+var errSkipExistingEditor = errors.New("pref: skip-existing import only supports the editors of PreferencesImpl")
+
+// Import reads the key-values in the format from r and puts them to the editor by the mode, the changes
+// are not committed. See Export for the types kept by each format, a sequence of strings is imported as
+// []string.
+func Import(editor Editor, r io.Reader, format Format, mode ImportMode) error {
+	m, err := importValues(r, format)
+	if err != nil {
+		return err
+	}
+	var exists func(string) bool
+	switch mode {
+	case ImportMerge:
+	case ImportReplace:
+		editor.Clear()
+	case ImportSkipExisting:
+		e, ok := editor.(*EditorImpl)
+		if !ok {
+			return errSkipExistingEditor
+		}
+		exists = e.pref.Contains
+	default:
+		return fmt.Errorf("pref: unknown import mode %d", mode)
+	}
+	for _, k := range sortedKeys(m) {
+		if exists != nil && exists(k) {
+			continue
+		}
+		editor.Put(k, m[k])
+	}
+	return nil
+}
+
+func importValues(r io.Reader, format Format) (map[string]interface{}, error) {
+	switch format {
+	case FormatJSON:
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		var m map[string]interface{}
+		if err := dec.Decode(&m); err != nil {
+			return nil, err
+		}
+		for k, v := range m {
+			m[k] = FromJSON(v)
+		}
+		return m, nil
+	case FormatYAML:
+		return importLines(r, ":", false)
+	case FormatTOML:
+		return importLines(r, "=", true)
+	case FormatXML:
+		return importXML(r)
+	case FormatGob:
+		var content fileContent
+		if err := gob.NewDecoder(r).Decode(&content); err != nil {
+			return nil, err
+		}
+		return decodeValues(content.Values), nil
+	}
+	return nil, fmt.Errorf("pref: unknown format %s", format)
+}
+
+// importLines reads the flat YAML or TOML document written by Export, every line is a key and a value
+// separated by sep. The table headers of TOML prefix the keys following them. The nested mappings, block
+// sequences and inline tables cannot be flattened, so they fail the import.
+func importLines(r io.Reader, sep string, tables bool) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	scanner := bufio.NewScanner(r)
+	prefix := ""
+	for n := 1; scanner.Scan(); n++ {
+		raw := stripComment(scanner.Text())
+		line := strings.TrimSpace(raw)
+		if line == "" || line == "---" || line == "..." {
+			continue
+		}
+		// The indentation of YAML nests a mapping or a sequence, which cannot be flattened to a key.
+		if !tables && raw != strings.TrimLeft(raw, " \t") {
+			return nil, fmt.Errorf("pref: nested value at line %d is not supported", n)
+		}
+		if !tables && (line == "-" || strings.HasPrefix(line, "- ")) {
+			return nil, fmt.Errorf("pref: block sequence at line %d is not supported", n)
+		}
+		if tables && strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name, err := parseKey(strings.Trim(line, "[] "))
+			if err != nil {
+				return nil, fmt.Errorf("pref: invalid table at line %d: %v", n, err)
+			}
+			prefix = name + "."
+			continue
+		}
+		i := keyEnd(line, sep)
+		if i < 0 {
+			return nil, fmt.Errorf("pref: missing %q at line %d", sep, n)
+		}
+		key, err := parseKey(strings.TrimSpace(line[:i]))
+		if err != nil {
+			return nil, fmt.Errorf("pref: invalid key at line %d: %v", n, err)
+		}
+		text := strings.TrimSpace(line[i+len(sep):])
+		// An empty value of YAML starts a nested block, and a flow mapping or an inline table of TOML is a
+		// nested table.
+		if text == "" {
+			return nil, fmt.Errorf("pref: missing value of key %s at line %d", key, n)
+		}
+		if strings.HasPrefix(text, "{") {
+			return nil, fmt.Errorf("pref: nested table of key %s at line %d is not supported", key, n)
+		}
+		v, err := parseText(text)
+		if err != nil {
+			return nil, fmt.Errorf("pref: invalid value of key %s at line %d: %v", key, n, err)
+		}
+		m[prefix+key] = v
+	}
+	return m, scanner.Err()
+}
+
+// keyEnd returns the index of the separator after the key, which may be quoted.
+func keyEnd(line string, sep string) int {
+	if strings.HasPrefix(line, `"`) {
+		if end := quotedEnd(line); end > 0 {
+			if i := strings.Index(line[end:], sep); i >= 0 {
+				return end + i
+			}
+		}
+		return -1
+	}
+	return strings.Index(line, sep)
+}
+
+func parseKey(text string) (string, error) {
+	if strings.HasPrefix(text, `"`) {
+		return strconv.Unquote(text)
+	}
+	if strings.HasPrefix(text, "'") {
+		return unquoteSingle(text)
+	}
+	return text, nil
+}
+
+// parseText parses a scalar or a flow sequence of scalars written by formatText. An unquoted text which
+// is not a bool or a number is a plain string of YAML.
+func parseText(text string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("unterminated sequence %s", text)
+		}
+		elems, err := splitSequence(strings.TrimSpace(text[1 : len(text)-1]))
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(elems))
+		for i, elem := range elems {
+			if values[i], err = parseText(elem); err != nil {
+				return nil, err
+			}
+		}
+		return homogeneous(values), nil
+	case strings.HasPrefix(text, `"`):
+		return strconv.Unquote(text)
+	case strings.HasPrefix(text, "'"):
+		return unquoteSingle(text)
+	case text == "true":
+		return true, nil
+	case text == "false":
+		return false, nil
+	case text == "inf" || text == "+inf" || text == ".inf":
+		return math.Inf(1), nil
+	case text == "-inf" || text == "-.inf":
+		return math.Inf(-1), nil
+	case text == "nan" || text == ".nan":
+		return math.NaN(), nil
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		if int64(int(i)) == i {
+			return int(i), nil
+		}
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		return f, nil
+	}
+	return text, nil
+}
+
+// unquoteSingle unquotes a single-quoted string of YAML or a literal string of TOML.
+func unquoteSingle(text string) (string, error) {
+	if len(text) < 2 || !strings.HasSuffix(text, "'") {
+		return "", fmt.Errorf("unterminated string %s", text)
+	}
+	return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+}
+
+// splitSequence splits the elements of a flow sequence by the commas outside of quotes.
+func splitSequence(text string) ([]string, error) {
+	elems := make([]string, 0)
+	if text == "" {
+		return elems, nil
+	}
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '"':
+			end := quotedEnd(text[i:])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string %s", text[i:])
+			}
+			i += end - 1
+		case ',':
+			elems = append(elems, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	// TOML allows a trailing comma.
+	if last := strings.TrimSpace(text[start:]); last != "" {
+		elems = append(elems, last)
+	}
+	return elems, nil
+}
+
+// quotedEnd returns the index after the closing quote of the double-quoted string at the start of text,
+// or -1 if it is not closed.
+func quotedEnd(text string) int {
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// stripComment removes the comment starting with a '#' outside of quotes, which must follow a space as YAML
+// requires.
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			end := quotedEnd(line[i:])
+			if end < 0 {
+				return line
+			}
+			i += end - 1
+		case '#':
+			if i == 0 || line[i-1] == ' ' || line[i-1] == '\t' {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// FromJSON converts a value decoded from JSON by a json.Decoder with UseNumber to the types of Import. A
+// number is converted to int if it is integral, otherwise to float64, and an array whose elements are all
+// strings, ints or float64s is converted to a slice of that type.
+func FromJSON(v interface{}) interface{} {
+	return homogeneous(fromJSON(v))
+}
+
+// homogeneous converts a slice whose elements are all strings, ints or float64s to a slice of that type.
+func homogeneous(v interface{}) interface{} {
+	values, ok := v.([]interface{})
+	if !ok || len(values) == 0 {
+		return v
+	}
+	switch values[0].(type) {
+	case string:
+		s := make([]string, len(values))
+		for i, v := range values {
+			if s[i], ok = v.(string); !ok {
+				return values
+			}
+		}
+		return s
+	case int:
+		s := make([]int, len(values))
+		for i, v := range values {
+			if s[i], ok = v.(int); !ok {
+				return values
+			}
+		}
+		return s
+	case float64:
+		s := make([]float64, len(values))
+		for i, v := range values {
+			if s[i], ok = v.(float64); !ok {
+				return values
+			}
+		}
+		return s
+	}
+	return values
+}
+
+// xmlEntry is an element in the XML file of Android's SharedPreferences.
+type xmlEntry struct {
+	XMLName xml.Name
+	Name    string   `xml:"name,attr"`
+	Value   string   `xml:"value,attr"`
+	Text    string   `xml:",chardata"`
+	Strings []string `xml:"string"`
+}
+
+// importXML reads the XML file of Android's SharedPreferences, an int is imported as int, a long as int64
+// and a float as float32.
+func importXML(r io.Reader) (map[string]interface{}, error) {
+	var doc struct {
+		Entries []xmlEntry `xml:",any"`
+	}
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{})
+	for _, e := range doc.Entries {
+		var v interface{}
+		var err error
+		switch e.XMLName.Local {
+		case "string":
+			v = e.Text
+		case "boolean":
+			v, err = strconv.ParseBool(e.Value)
+		case "int":
+			v, err = ParseValue("int", e.Value)
+		case "long":
+			v, err = ParseValue("int64", e.Value)
+		case "float":
+			v, err = ParseValue("float32", e.Value)
+		case "set":
+			if e.Strings == nil {
+				e.Strings = []string{}
+			}
+			v = e.Strings
+		case "null":
+			continue
+		default:
+			return nil, fmt.Errorf("pref: unknown element %s of key %s", e.XMLName.Local, e.Name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pref: invalid value of key %s: %v", e.Name, err)
+		}
+		m[e.Name] = v
+	}
+	return m, nil
+}
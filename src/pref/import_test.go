@@ -0,0 +1,192 @@
+package pref
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"strings"
+	"testing"
+)
+
+type ImportTestSuite struct {
+	suite.Suite
+	values map[string]interface{}
+}
+
+func (suite *ImportTestSuite) SetupTest() {
+	basePath = "./"
+	pref = newPreferencesImpl(PrefName)
+	suite.values = map[string]interface{}{
+		"count":     3,
+		"ratio":     0.5,
+		"whole":     2.0,
+		"enabled":   true,
+		"name":      "a<b \"c\" # not a comment\n",
+		"tags":      []string{"x", "y, z"},
+		"app.title": "t",
+		"odd key":   "z",
+	}
+}
+
+func (suite *ImportTestSuite) TearDownTest() {
+	os.Remove(basePath + PrefName)
+	os.Remove(basePath + PrefName + "_bak")
+	os.Remove(basePath + OtherPrefName)
+	os.Remove(basePath + OtherPrefName + "_bak")
+}
+
+func TestImportTestSuite(t *testing.T) {
+	suite.Run(t, new(ImportTestSuite))
+}
+
+func (suite *ImportTestSuite) roundTrip(format Format) map[string]interface{} {
+	editor := pref.Edit()
+	for k, v := range suite.values {
+		editor.Put(k, v)
+	}
+	suite.True(editor.Commit())
+	var buf bytes.Buffer
+	suite.Nil(Export(pref, &buf, format))
+
+	other := newPreferencesImpl(OtherPrefName)
+	editor = other.Edit()
+	suite.Nil(Import(editor, &buf, format, ImportMerge))
+	suite.True(editor.Commit())
+	return other.GetAll()
+}
+
+func (suite *ImportTestSuite) TestJSON() {
+	values := suite.values
+	values["whole"] = 2
+	suite.Equal(values, suite.roundTrip(FormatJSON))
+}
+
+func (suite *ImportTestSuite) TestYAML() {
+	suite.Equal(suite.values, suite.roundTrip(FormatYAML))
+}
+
+func (suite *ImportTestSuite) TestTOML() {
+	suite.Equal(suite.values, suite.roundTrip(FormatTOML))
+}
+
+func (suite *ImportTestSuite) TestXML() {
+	values := suite.values
+	values["ratio"] = float32(0.5)
+	values["whole"] = float32(2)
+	suite.Equal(values, suite.roundTrip(FormatXML))
+}
+
+func (suite *ImportTestSuite) TestGob() {
+	suite.values["total"] = int64(7)
+	suite.values["small"] = float32(1.5)
+	suite.values["point"] = knownPoint{X: 1, Y: 2}
+	suite.Equal(suite.values, suite.roundTrip(FormatGob))
+}
+
+func (suite *ImportTestSuite) TestHandWritten() {
+	yaml := `---
+# settings
+name: plain text
+quoted: 'it''s'
+ids: [1, 2, 3]
+`
+	editor := pref.Edit()
+	suite.Nil(Import(editor, strings.NewReader(yaml), FormatYAML, ImportMerge))
+	suite.True(editor.Commit())
+	suite.Equal("plain text", pref.GetString("name", ""))
+	suite.Equal("it's", pref.GetString("quoted", ""))
+	suite.Equal([]int{1, 2, 3}, pref.GetObject("ids", nil))
+
+	toml := `title = "root" # comment
+[server]
+port = 8080
+hosts = ["a", "b",]
+`
+	editor = pref.Edit()
+	suite.Nil(Import(editor, strings.NewReader(toml), FormatTOML, ImportMerge))
+	suite.True(editor.Commit())
+	suite.Equal("root", pref.GetString("title", ""))
+	suite.Equal(8080, pref.GetInt("server.port", 0))
+	suite.Equal([]string{"a", "b"}, pref.GetObject("server.hosts", nil))
+}
+
+func (suite *ImportTestSuite) TestNestedInput() {
+	for _, yaml := range []string{
+		"server:\n  port: 8080\n",
+		"server:\n",
+		"server: {port: 8080}\n",
+		"hosts:\n- a\n- b\n",
+	} {
+		editor := pref.Edit()
+		suite.Error(Import(editor, strings.NewReader(yaml), FormatYAML, ImportMerge), yaml)
+		suite.True(editor.Commit())
+		suite.Empty(pref.GetAll())
+	}
+	for _, toml := range []string{
+		"server = {port = 8080}\n",
+		"[app]\nname =\n",
+	} {
+		suite.Error(Import(pref.Edit(), strings.NewReader(toml), FormatTOML, ImportMerge), toml)
+	}
+
+	// The indentation of TOML is insignificant.
+	editor := pref.Edit()
+	suite.Nil(Import(editor, strings.NewReader("[server]\n  port = 8080\n"), FormatTOML, ImportMerge))
+	suite.True(editor.Commit())
+	suite.Equal(map[string]interface{}{"server.port": 8080}, pref.GetAll())
+}
+
+func (suite *ImportTestSuite) TestAndroidXML() {
+	xml := `<?xml version='1.0' encoding='utf-8' standalone='yes' ?>
+<map>
+    <string name="token">abc</string>
+    <int name="launches" value="12" />
+    <long name="installed" value="1600000000000" />
+    <float name="volume" value="0.75" />
+    <boolean name="dark" value="false" />
+    <set name="langs">
+        <string>en</string>
+        <string>fr</string>
+    </set>
+    <null name="missing" />
+</map>`
+	editor := pref.Edit()
+	suite.Nil(Import(editor, strings.NewReader(xml), FormatXML, ImportMerge))
+	suite.True(editor.Commit())
+	suite.Equal(map[string]interface{}{
+		"token":     "abc",
+		"launches":  12,
+		"installed": int64(1600000000000),
+		"volume":    float32(0.75),
+		"dark":      false,
+		"langs":     []string{"en", "fr"},
+	}, pref.GetAll())
+}
+
+func (suite *ImportTestSuite) TestModes() {
+	pref.Edit().Put("a", 1).Put("b", 2).Commit()
+	input := `{"b": 20, "c": 30}`
+
+	editor := pref.Edit()
+	suite.Nil(Import(editor, strings.NewReader(input), FormatJSON, ImportSkipExisting))
+	suite.True(editor.Commit())
+	suite.Equal(map[string]interface{}{"a": 1, "b": 2, "c": 30}, pref.GetAll())
+
+	editor = pref.Edit()
+	suite.Nil(Import(editor, strings.NewReader(`{"a": 10}`), FormatJSON, ImportMerge))
+	suite.True(editor.Commit())
+	suite.Equal(map[string]interface{}{"a": 10, "b": 2, "c": 30}, pref.GetAll())
+
+	editor = pref.Edit()
+	suite.Nil(Import(editor, strings.NewReader(input), FormatJSON, ImportReplace))
+	suite.True(editor.Commit())
+	suite.Equal(map[string]interface{}{"b": 20, "c": 30}, pref.GetAll())
+}
+
+func (suite *ImportTestSuite) TestInvalidInput() {
+	suite.Error(Import(pref.Edit(), strings.NewReader("key value"), FormatYAML, ImportMerge))
+	suite.Error(Import(pref.Edit(), strings.NewReader("key = [1, 2"), FormatTOML, ImportMerge))
+	suite.Error(Import(pref.Edit(), strings.NewReader(`<map><int name="a" value="x" /></map>`), FormatXML, ImportMerge))
+	suite.Error(Import(pref.Edit(), strings.NewReader("{}"), FormatJSON, ImportMode(9)))
+	suite.Error(Import(pref.Edit(), strings.NewReader("{}"), "csv", ImportMerge))
+}
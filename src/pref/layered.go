@@ -0,0 +1,295 @@
+package pref
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Source is the layer of a Layered which a value comes from.
+type Source int
+
+const (
+	// SourceNone means the key is not set in any layer.
+	SourceNone Source = iota
+	// SourceDefault is the default values registered by SetDefaults or declared in the schema.
+	SourceDefault
+	// SourceSystem is the read-only system file.
+	SourceSystem
+	// SourceUser is the writable Preferences of the user.
+	SourceUser
+	// SourceEnv is the PREF_<NAME>_<KEY> environment variables.
+	SourceEnv
+	// SourceFlag is the command-line flags.
+	SourceFlag
+)
+
+// String returns the name of the source.
+func (s Source) String() string {
+	switch s {
+	case SourceDefault:
+		return "default"
+	case SourceSystem:
+		return "system"
+	case SourceUser:
+		return "user"
+	case SourceEnv:
+		return "env"
+	case SourceFlag:
+		return "flag"
+	}
+	return "none"
+}
+
+// Layered is a Preferences which resolves every key through an ordered stack of layers, a later layer
+// overrides the earlier ones: the registered defaults, the read-only system file, the writable Preferences
+// of the user, the environment variables and the command-line flags. The editors write to the user layer,
+// so a written value is shadowed while an environment variable or a flag of the key is set. The listeners
+// and the revision are the ones of the user layer.
+type Layered struct {
+	TypedGetters
+	user   *PreferencesImpl
+	system map[string]interface{}
+	// env maps the names of the environment variables to their values.
+	env map[string]string
+	// flags keeps the values of the flags which have been set on the command line.
+	flags map[string]interface{}
+}
+
+// LayerOption configures a Layered when it is created by NewLayered.
+type LayerOption func(*Layered) error
+
+// WithSystemFile reads the system layer from a file in the format, such as one exported by Export. A
+// file which does not exist leaves the layer empty.
+func WithSystemFile(path string, format Format) LayerOption {
+	return func(l *Layered) error {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		defer file.Close()
+		m, err := importValues(file, format)
+		if err != nil {
+			return fmt.Errorf("pref: cannot read system file %s: %v", path, err)
+		}
+		l.system = m
+		return nil
+	}
+}
+
+// WithEnviron sets the environment variables in the form of "key=value" as os.Environ, which is the
+// default.
+func WithEnviron(environ []string) LayerOption {
+	return func(l *Layered) error {
+		l.env = make(map[string]string)
+		for _, kv := range environ {
+			if i := strings.Index(kv, "="); i > 0 {
+				l.env[kv[:i]] = kv[i+1:]
+			}
+		}
+		return nil
+	}
+}
+
+// WithFlags reads the flag layer from the flags of fs which have been set, a flag overrides the key of
+// its name. The flags must have been parsed before NewLayered is called.
+func WithFlags(fs *flag.FlagSet) LayerOption {
+	return func(l *Layered) error {
+		fs.Visit(func(f *flag.Flag) {
+			if getter, ok := f.Value.(flag.Getter); ok {
+				l.flags[f.Name] = getter.Get()
+			} else {
+				l.flags[f.Name] = f.Value.String()
+			}
+		})
+		return nil
+	}
+}
+
+// NewLayered creates a Layered on top of the writable Preferences of the user, the layers of the options
+// are read once when it is created.
+func NewLayered(user *PreferencesImpl, options ...LayerOption) (*Layered, error) {
+	l := &Layered{
+		user:   user,
+		system: make(map[string]interface{}),
+		flags:  make(map[string]interface{}),
+	}
+	l.TypedGetters = TypedGetters{l.GetObject}
+	if err := WithEnviron(os.Environ())(l); err != nil {
+		return nil, err
+	}
+	for _, option := range options {
+		if err := option(l); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// EnvName returns the name of the environment variable which overrides a key of the named Preferences,
+// the letters are upper-cased and the other characters than letters and digits are replaced by '_'.
+func EnvName(name, key string) string {
+	return "PREF_" + envPart(name) + "_" + envPart(key)
+}
+
+func envPart(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// resolve returns the value of key from the highest layer which has it, and the layer. The text of an
+// environment variable or a flag is converted to the type of the value of the lower layers, or the type of
+// like if they do not have the key.
+func (l *Layered) resolve(key string, like interface{}) (interface{}, Source) {
+	v, exist := l.user.defaultValue(key)
+	src := SourceNone
+	if exist {
+		src = SourceDefault
+	}
+	if sv, exist := l.system[key]; exist {
+		v, src = sv, SourceSystem
+	}
+	l.user.loadWg.Wait()
+	if uv, exist := l.user.lookup(l.user.values(), key); exist {
+		if resolved, ok := resolve(uv); ok {
+			v, src = resolved, SourceUser
+		}
+	}
+	if v != nil {
+		like = v
+	}
+	if text, exist := l.env[EnvName(l.user.name, key)]; exist {
+		if ev, ok := convert(key, text, like); ok {
+			v, src = ev, SourceEnv
+		}
+	}
+	if fv, exist := l.flags[key]; exist {
+		if fv, ok := convert(key, fv, like); ok {
+			v, src = fv, SourceFlag
+		}
+	}
+	return v, src
+}
+
+// convert converts the value of an environment variable or a flag to the type of like by its text, the
+// value is kept if it already has the type or like is not of a type supported by ParseValue.
+func convert(key string, v interface{}, like interface{}) (interface{}, bool) {
+	typeName := TypeName(like)
+	if typeName == "" || TypeName(v) == typeName {
+		return v, true
+	}
+	converted, err := ParseValue(typeName, fmt.Sprint(v))
+	if err != nil {
+		log.Printf("Error when convert the override of key %s: %v", key, err)
+		return nil, false
+	}
+	return converted, true
+}
+
+// Source returns the layer which the value of key comes from.
+func (l *Layered) Source(key string) Source {
+	_, src := l.resolve(key, nil)
+	return src
+}
+
+// Contains returns whether a key is set in any layer other than the defaults.
+func (l *Layered) Contains(key string) bool {
+	return l.Source(key) > SourceDefault
+}
+
+// GetObject returns the value of key from the highest layer which has it, and return default value if no
+// layer has it. The text of an environment variable or a flag is converted to the type of defaultValue
+// if the lower layers do not have the key.
+func (l *Layered) GetObject(key string, defaultValue interface{}) interface{} {
+	v, src := l.resolve(key, defaultValue)
+	if src == SourceNone {
+		return defaultValue
+	}
+	return v
+}
+
+// GetAll returns the resolved values of the keys set in the system, user and flag layers, the
+// environment variables can only override these keys since their names cannot be mapped back to keys.
+func (l *Layered) GetAll() map[string]interface{} {
+	all := make(map[string]interface{})
+	keys := make([]string, 0)
+	for k := range l.system {
+		keys = append(keys, k)
+	}
+	for k := range l.user.GetAll() {
+		keys = append(keys, k)
+	}
+	for k := range l.flags {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		if v, src := l.resolve(k, nil); src > SourceDefault {
+			all[k] = v
+		}
+	}
+	return all
+}
+
+// SetDefaults registers the default values of keys to the user layer.
+func (l *Layered) SetDefaults(defaults map[string]interface{}) {
+	l.user.SetDefaults(defaults)
+}
+
+// GetOrDefault returns the value of a key from the highest layer, or nil if no layer has it.
+func (l *Layered) GetOrDefault(key string) interface{} {
+	return l.GetObject(key, nil)
+}
+
+// IsDefault returns whether the value of a key is its registered default value, either because no layer
+// other than the defaults has the key or because it is set to the same value.
+func (l *Layered) IsDefault(key string) bool {
+	v, src := l.resolve(key, nil)
+	if src <= SourceDefault {
+		return true
+	}
+	registered, exist := l.user.defaultValue(key)
+	return exist && reflect.DeepEqual(v, registered)
+}
+
+// RegisterOnPreferenceChangeListener registers the listener to the user layer.
+func (l *Layered) RegisterOnPreferenceChangeListener(observer OnPreferenceChangeListener) {
+	l.user.RegisterOnPreferenceChangeListener(observer)
+}
+
+// UnregisterOnPreferenceChangeListener unregisters the listener from the user layer.
+func (l *Layered) UnregisterOnPreferenceChangeListener(observer OnPreferenceChangeListener) {
+	l.user.UnregisterOnPreferenceChangeListener(observer)
+}
+
+// Revision returns the revision of the user layer.
+func (l *Layered) Revision() uint64 {
+	return l.user.Revision()
+}
+
+// Edit creates an editor of the user layer.
+func (l *Layered) Edit() Editor {
+	return l.user.Edit()
+}
+
+// EditAt creates an editor of the user layer based on the given revision.
+func (l *Layered) EditAt(revision uint64) Editor {
+	return l.user.EditAt(revision)
+}
+
+// Update runs fn in a transaction of the user layer, the reads of the transaction only see the user layer
+// and its defaults.
+func (l *Layered) Update(fn func(Tx) error) error {
+	return l.user.Update(fn)
+}
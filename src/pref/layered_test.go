@@ -0,0 +1,94 @@
+package pref
+
+import (
+	"flag"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const systemFile = "./system_test.json"
+
+var _ Preferences = (*Layered)(nil)
+
+type LayeredTestSuite struct {
+	suite.Suite
+}
+
+func (suite *LayeredTestSuite) SetupTest() {
+	basePath = "./"
+	pref = newPreferencesImpl(PrefName)
+	ioutil.WriteFile(systemFile, []byte(`{"theme": "dark", "port": 8080, "debug": false}`), 0644)
+}
+
+func (suite *LayeredTestSuite) TearDownTest() {
+	os.Remove(basePath + PrefName)
+	os.Remove(basePath + PrefName + "_bak")
+	os.Remove(systemFile)
+}
+
+func TestLayeredTestSuite(t *testing.T) {
+	suite.Run(t, new(LayeredTestSuite))
+}
+
+func (suite *LayeredTestSuite) layered(env []string, args ...string) *Layered {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("port", 0, "")
+	fs.String("name", "", "")
+	suite.NoError(fs.Parse(args))
+	l, err := NewLayered(pref, WithSystemFile(systemFile, FormatJSON), WithEnviron(env), WithFlags(fs))
+	suite.NoError(err)
+	return l
+}
+
+func (suite *LayeredTestSuite) TestResolveOrder() {
+	pref.SetDefaults(map[string]interface{}{"theme": "light", "lang": "en"})
+	l := suite.layered(nil)
+	suite.Equal("en", l.GetString("lang", ""))
+	suite.Equal(SourceDefault, l.Source("lang"))
+	suite.Equal("dark", l.GetString("theme", ""))
+	suite.Equal(SourceSystem, l.Source("theme"))
+	suite.Equal(SourceNone, l.Source("missing"))
+	suite.Equal("none", l.GetString("missing", "none"))
+
+	suite.True(l.Edit().Put("theme", "blue").Commit())
+	suite.Equal("blue", l.GetString("theme", ""))
+	suite.Equal(SourceUser, l.Source("theme"))
+	suite.Equal("blue", pref.GetString("theme", ""))
+}
+
+func (suite *LayeredTestSuite) TestEnvAndFlags() {
+	env := []string{"PREF_" + envPart(PrefName) + "_DEBUG=true", "PREF_" + envPart(PrefName) + "_PORT=9090", "OTHER=1"}
+	l := suite.layered(env, "-port=7070", "-name=alice")
+	suite.Equal("PREF_"+envPart(PrefName)+"_LOG_LEVEL", EnvName(PrefName, "log.level"))
+
+	// The text of the environment variable is converted to the type of the system value.
+	suite.Equal(true, l.GetBool("debug", false))
+	suite.Equal(SourceEnv, l.Source("debug"))
+	suite.Equal(7070, l.GetInt("port", 0))
+	suite.Equal(SourceFlag, l.Source("port"))
+	suite.Equal("alice", l.GetString("name", ""))
+
+	suite.True(l.Edit().Put("debug", false).Commit())
+	suite.True(l.GetBool("debug", false))
+	suite.Equal(map[string]interface{}{"theme": "dark", "port": 7070, "debug": true, "name": "alice"}, l.GetAll())
+	suite.False(l.IsDefault("debug"))
+}
+
+func (suite *LayeredTestSuite) TestInvalidOverride() {
+	l := suite.layered([]string{"PREF_" + envPart(PrefName) + "_PORT=abc"})
+	suite.Equal(8080, l.GetInt("port", 0))
+	suite.Equal(SourceSystem, l.Source("port"))
+}
+
+func (suite *LayeredTestSuite) TestMissingSystemFile() {
+	l, err := NewLayered(pref, WithSystemFile("./missing.json", FormatJSON), WithEnviron(nil))
+	suite.NoError(err)
+	suite.False(l.Contains("theme"))
+	suite.True(l.IsDefault("theme"))
+
+	ioutil.WriteFile(systemFile, []byte("{"), 0644)
+	_, err = NewLayered(pref, WithSystemFile(systemFile, FormatJSON))
+	suite.Error(err)
+}
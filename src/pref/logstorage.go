@@ -0,0 +1,138 @@
+package pref
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+)
+
+// DefaultCompactThreshold is the default size in bytes of the log which triggers a compaction.
+const DefaultCompactThreshold = 1 << 20
+
+// logStorage appends the records of every commit to a write-ahead log, and only rewrites the whole map to
+// the snapshot file when the log grows past the compact threshold. The log is a frameLog whose frames
+// hold the records of one commit each. Every compaction increases the
+// generation stored with the snapshot, and the frames of older generations are ignored, so a log which
+// was not removed after the snapshot had been written is never replayed over it.
+type logStorage struct {
+	snapshot  *fileStorage
+	log       *frameLog
+	threshold int64
+	// stamp is the fingerprint of the snapshot and the log when they were last loaded or saved.
+	stamp string
+}
+
+func newLogStorage(path string, threshold int64) *logStorage {
+	return &logStorage{
+		snapshot:  newFileStorage(path),
+		log:       &frameLog{path: path + ".log"},
+		threshold: threshold,
+	}
+}
+
+// Load reads the last snapshot and replays the log over it.
+func (s *logStorage) Load() (map[string]interface{}, error) {
+	defer s.updateStamp()
+	m, err := s.snapshot.Load()
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.log.replay(func(data []byte) error {
+		generation, records, err := decodeFrame(data)
+		if err != nil {
+			return err
+		}
+		if generation == s.snapshot.generation {
+			applyRecords(m, records)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Save appends the records to the log, and compacts the log into the snapshot if it is too large.
+func (s *logStorage) Save(m map[string]interface{}, records []Record) error {
+	defer s.updateStamp()
+	if s.log.size >= s.threshold {
+		return s.compact(m)
+	}
+	data, err := encodeFrame(s.snapshot.generation, records)
+	if err != nil {
+		return err
+	}
+	return s.log.append(data)
+}
+
+// Changed returns whether the snapshot or the log has been modified since they were last loaded or saved.
+func (s *logStorage) Changed() bool {
+	return fingerprint(s.snapshot.path, s.log.path) != s.stamp
+}
+
+func (s *logStorage) updateStamp() {
+	s.stamp = fingerprint(s.snapshot.path, s.log.path)
+}
+
+// compact writes the whole map to the snapshot of the next generation and removes the log. If it is
+// interrupted after the snapshot is written, the frames left in the log are of the previous generation,
+// so they are ignored on next load. The snapshot is synced to disk before the log is removed, otherwise a
+// crash could lose both of them.
+func (s *logStorage) compact(m map[string]interface{}) error {
+	generation := s.snapshot.generation
+	s.snapshot.generation++
+	err := s.snapshot.Save(m, nil)
+	if err == nil {
+		err = syncPath(s.snapshot.path)
+	}
+	if err == nil {
+		err = syncPath(filepath.Dir(s.snapshot.path))
+	}
+	if err != nil {
+		// The snapshot of the previous generation is restored by the failed save, and a snapshot which
+		// has not been synced is overwritten by the next compaction.
+		s.snapshot.generation = generation
+		return err
+	}
+	return s.log.remove()
+}
+
+// syncPath commits the file or directory at path to disk.
+func syncPath(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// logFrame is the content of a frame in the log.
+type logFrame struct {
+	Generation uint64
+	Records    []encodedRecord
+}
+
+// encodeFrame encodes the records of a commit as the data of a frame in the log.
+func encodeFrame(generation uint64, records []Record) ([]byte, error) {
+	encoded, err := encodeRecords(records)
+	if err != nil {
+		return nil, err
+	}
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(&logFrame{Generation: generation, Records: encoded}); err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+// decodeFrame decodes the generation and the records from the data of a frame in the log.
+func decodeFrame(data []byte) (uint64, []Record, error) {
+	var content logFrame
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&content); err != nil {
+		return 0, nil, err
+	}
+	return content.Generation, decodeRecords(content.Records), nil
+}
@@ -0,0 +1,127 @@
+package pref
+
+import (
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type LogStorageTestSuite struct {
+	suite.Suite
+}
+
+func (suite *LogStorageTestSuite) SetupTest() {
+	basePath = "./"
+	pref = newPreferencesImpl(PrefName)
+	WithLogStorage(DefaultCompactThreshold)(pref)
+}
+
+func (suite *LogStorageTestSuite) TearDownTest() {
+	os.Remove(basePath + PrefName)
+	os.Remove(basePath + PrefName + "_bak")
+	os.Remove(basePath + PrefName + ".log")
+}
+
+func TestLogStorageTestSuite(t *testing.T) {
+	suite.Run(t, new(LogStorageTestSuite))
+}
+
+func (suite *LogStorageTestSuite) reload() {
+	pref = newPreferencesImpl(PrefName)
+	WithLogStorage(DefaultCompactThreshold)(pref)
+	pref.loadWg.Add(1)
+	go pref.loadFromFile()
+	pref.loadWg.Wait()
+}
+
+func (suite *LogStorageTestSuite) TestAppendRecords() {
+	pref.Edit().Put("key1", 1).Put("key2", "value").Commit()
+	_, err := os.Stat(basePath + PrefName)
+	suite.True(os.IsNotExist(err))
+	info, err := os.Stat(basePath + PrefName + ".log")
+	suite.Nil(err)
+	size := info.Size()
+	pref.Edit().Remove("key1").Commit()
+	info, _ = os.Stat(basePath + PrefName + ".log")
+	suite.True(info.Size() > size)
+
+	suite.reload()
+	suite.Len(pref.values(), 1)
+	suite.Equal(pref.values()["key2"], "value")
+}
+
+func (suite *LogStorageTestSuite) TestReplayClear() {
+	pref.Edit().Put("key1", 1).Put("key2", 2).Commit()
+	pref.Edit().Clear().Put("key2", 2).Put("key3", 3).Commit()
+
+	suite.reload()
+	suite.Len(pref.values(), 2)
+	suite.Equal(pref.values()["key2"], 2)
+	suite.Equal(pref.values()["key3"], 3)
+}
+
+func (suite *LogStorageTestSuite) TestCompact() {
+	pref.storage.(*logStorage).threshold = 1
+	pref.Edit().Put("key1", 1).Commit()
+	_, err := os.Stat(basePath + PrefName + ".log")
+	suite.Nil(err)
+	pref.Edit().Put("key2", 2).Commit()
+	_, err = os.Stat(basePath + PrefName + ".log")
+	suite.True(os.IsNotExist(err))
+	_, err = os.Stat(basePath + PrefName)
+	suite.Nil(err)
+
+	suite.reload()
+	suite.Len(pref.values(), 2)
+	pref.Edit().Put("key3", 3).Commit()
+	suite.reload()
+	suite.Len(pref.values(), 3)
+}
+
+func (suite *LogStorageTestSuite) TestCrashBeforeLogRemoved() {
+	pref.Edit().Put("key1", 1).Commit()
+	pref.Edit().Clear().Put("key2", 2).Commit()
+	log, err := ioutil.ReadFile(basePath + PrefName + ".log")
+	suite.Nil(err)
+	pref.storage.(*logStorage).threshold = 1
+	pref.Edit().Put("key3", 3).Commit()
+	// Simulate a crash after the snapshot is written but before the log is removed.
+	suite.Nil(ioutil.WriteFile(basePath+PrefName+".log", log, 0644))
+
+	suite.reload()
+	suite.Equal(map[string]interface{}{"key2": 2, "key3": 3}, pref.values())
+	pref.Edit().Put("key4", 4).Commit()
+	suite.reload()
+	suite.Equal(map[string]interface{}{"key2": 2, "key3": 3, "key4": 4}, pref.values())
+}
+
+func (suite *LogStorageTestSuite) TestTornFrame() {
+	pref.Edit().Put("key1", 1).Commit()
+	pref.Edit().Put("key2", 2).Commit()
+	info, _ := os.Stat(basePath + PrefName + ".log")
+	os.Truncate(basePath+PrefName+".log", info.Size()-1)
+
+	suite.reload()
+	suite.Len(pref.values(), 1)
+	suite.Equal(pref.values()["key1"], 1)
+	pref.Edit().Put("key3", 3).Commit()
+	suite.reload()
+	suite.Len(pref.values(), 2)
+	suite.Equal(pref.values()["key3"], 3)
+}
+
+func (suite *LogStorageTestSuite) TestChanged() {
+	pref.Edit().Put("key1", 1).Commit()
+	suite.False(pref.storageChanged())
+
+	other := newPreferencesImpl(PrefName)
+	WithLogStorage(DefaultCompactThreshold)(other)
+	other.loadWg.Add(1)
+	other.loadFromFile()
+	other.Edit().Put("key2", 2).Commit()
+	suite.True(pref.storageChanged())
+	suite.True(pref.Reload())
+	suite.Equal(2, pref.GetInt("key2", 0))
+	suite.False(pref.storageChanged())
+}
@@ -0,0 +1,73 @@
+package pref
+
+import (
+	"reflect"
+	"time"
+)
+
+// Conflict is a key changed differently by both sides of a three-way merge, a nil value means the key does
+// not exist on that side.
+type Conflict struct {
+	Key    string
+	Base   interface{}
+	Ours   interface{}
+	Theirs interface{}
+}
+
+// Resolver decides the merged value of a conflict, a nil value removes the key. An error aborts the merge.
+type Resolver func(Conflict) (interface{}, error)
+
+// ResolveOurs resolves every conflict with our value.
+func ResolveOurs(c Conflict) (interface{}, error) {
+	return c.Ours, nil
+}
+
+// ResolveTheirs resolves every conflict with their value.
+func ResolveTheirs(c Conflict) (interface{}, error) {
+	return c.Theirs, nil
+}
+
+// LastWriterWins resolves every conflict with the value of the side modified later, such as the time of
+// the last commit or the modification time of its storage. Our value wins a tie.
+func LastWriterWins(oursModified, theirsModified time.Time) Resolver {
+	if theirsModified.After(oursModified) {
+		return ResolveTheirs
+	}
+	return ResolveOurs
+}
+
+// Merge merges the changes made by ours and theirs since base, and returns the changes which bring ours to
+// the merged key-values, see ApplyChanges. A key changed by only one side takes the value of that side,
+// and a key changed differently by both sides is decided by resolve.
+func Merge(base, ours, theirs Preferences, resolve Resolver) ([]Change, error) {
+	return mergeValues(base.GetAll(), ours.GetAll(), theirs.GetAll(), resolve)
+}
+
+func mergeValues(base, ours, theirs map[string]interface{}, resolve Resolver) ([]Change, error) {
+	keys := make(map[string]interface{}, len(ours)+len(theirs))
+	for _, m := range []map[string]interface{}{base, ours, theirs} {
+		for k := range m {
+			keys[k] = nil
+		}
+	}
+	changes := make([]Change, 0)
+	for _, k := range sortedKeys(keys) {
+		b, o, t := base[k], ours[k], theirs[k]
+		var merged interface{}
+		switch {
+		case reflect.DeepEqual(o, t), reflect.DeepEqual(t, b):
+			merged = o
+		case reflect.DeepEqual(o, b):
+			merged = t
+		default:
+			var err error
+			if merged, err = resolve(Conflict{Key: k, Base: b, Ours: o, Theirs: t}); err != nil {
+				return nil, err
+			}
+		}
+		if change, changed := changeOf(k, o, merged); changed {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,85 @@
+package pref
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type MergeTestSuite struct {
+	suite.Suite
+	base, ours, theirs *PreferencesImpl
+}
+
+func (suite *MergeTestSuite) SetupTest() {
+	suite.base = memPreferences("base", map[string]interface{}{
+		"unchanged": 1, "ours": 1, "theirs": 1, "both": 1, "conflict": 1, "removed": 1,
+	})
+	suite.ours = memPreferences("ours", map[string]interface{}{
+		"unchanged": 1, "ours": 2, "theirs": 1, "both": 2, "conflict": 2, "removed": 1, "new": "ours",
+	})
+	suite.theirs = memPreferences("theirs", map[string]interface{}{
+		"unchanged": 1, "ours": 1, "theirs": 3, "both": 2, "conflict": 3, "added": "theirs",
+	})
+}
+
+func TestMergeTestSuite(t *testing.T) {
+	suite.Run(t, new(MergeTestSuite))
+}
+
+func (suite *MergeTestSuite) TestResolveOurs() {
+	changes, err := Merge(suite.base, suite.ours, suite.theirs, ResolveOurs)
+	suite.Nil(err)
+	suite.Equal([]Change{
+		{Key: "added", Kind: Added, New: "theirs"},
+		{Key: "removed", Kind: Removed, Old: 1},
+		{Key: "theirs", Kind: Modified, Old: 1, New: 3},
+	}, changes)
+}
+
+func (suite *MergeTestSuite) TestResolveTheirs() {
+	changes, err := Merge(suite.base, suite.ours, suite.theirs, ResolveTheirs)
+	suite.Nil(err)
+	suite.Len(changes, 4)
+	suite.Equal(Change{Key: "conflict", Kind: Modified, Old: 2, New: 3}, changes[1])
+}
+
+func (suite *MergeTestSuite) TestLastWriterWins() {
+	now := time.Now()
+	changes, err := Merge(suite.base, suite.ours, suite.theirs, LastWriterWins(now, now.Add(time.Second)))
+	suite.Nil(err)
+	suite.Len(changes, 4)
+	changes, err = Merge(suite.base, suite.ours, suite.theirs, LastWriterWins(now, now))
+	suite.Nil(err)
+	suite.Len(changes, 3)
+}
+
+func (suite *MergeTestSuite) TestCustomResolver() {
+	var conflicts []Conflict
+	changes, err := Merge(suite.base, suite.ours, suite.theirs, func(c Conflict) (interface{}, error) {
+		conflicts = append(conflicts, c)
+		return c.Ours.(int) + c.Theirs.(int), nil
+	})
+	suite.Nil(err)
+	suite.Equal([]Conflict{{Key: "conflict", Base: 1, Ours: 2, Theirs: 3}}, conflicts)
+	suite.Contains(changes, Change{Key: "conflict", Kind: Modified, Old: 2, New: 5})
+
+	_, err = Merge(suite.base, suite.ours, suite.theirs, func(c Conflict) (interface{}, error) {
+		return nil, errors.New("conflict")
+	})
+	suite.Error(err)
+}
+
+func (suite *MergeTestSuite) TestRemoveConflict() {
+	// Ours removed a key which theirs modified.
+	base := memPreferences("base", map[string]interface{}{"key": 1})
+	ours := memPreferences("ours", map[string]interface{}{})
+	theirs := memPreferences("theirs", map[string]interface{}{"key": 2})
+	changes, err := Merge(base, ours, theirs, ResolveOurs)
+	suite.Nil(err)
+	suite.Empty(changes)
+	changes, err = Merge(base, ours, theirs, ResolveTheirs)
+	suite.Nil(err)
+	suite.Equal([]Change{{Key: "key", Kind: Added, New: 2}}, changes)
+}
@@ -0,0 +1,112 @@
+package pref
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// reservedPrefix is the prefix of the keys reserved for the Preferences itself, they are kept by
+// Editor.Clear.
+const reservedPrefix = "\x00"
+
+// versionKey is the reserved key of the version of the key-values, it is only stored when the Preferences
+// has migrations.
+const versionKey = reservedPrefix + "version"
+
+// Migration upgrades the key-values of a Preferences from one version to the next, it modifies m in place.
+type Migration func(m map[string]interface{}) error
+
+// WithMigrations declares the migrations of the Preferences in order, the i-th migration upgrades the
+// key-values from version i+1 to version i+2, so the current version is len(migrations)+1 and the key-values
+// stored without a version are at version 1. The migrations run once when the Preferences is loaded, before
+// any getter returns, and the upgraded key-values are stored with the current version. If a migration
+// fails, the key-values are loaded as they are and migrated again on next load.
+func WithMigrations(migrations ...Migration) Option {
+	return func(p *PreferencesImpl) {
+		p.migrations = migrations
+	}
+}
+
+// RenameKey returns a migration which moves the value of a key to a new key.
+func RenameKey(oldKey, newKey string) Migration {
+	return func(m map[string]interface{}) error {
+		if v, exist := m[oldKey]; exist {
+			m[newKey] = v
+			delete(m, oldKey)
+		}
+		return nil
+	}
+}
+
+// RemoveKeys returns a migration which removes the keys.
+func RemoveKeys(keys ...string) Migration {
+	return func(m map[string]interface{}) error {
+		for _, key := range keys {
+			delete(m, key)
+		}
+		return nil
+	}
+}
+
+// ConvertKey returns a migration which converts the value of a key, such as to another type. The key is
+// removed if convert returns nil.
+func ConvertKey(key string, convert func(interface{}) (interface{}, error)) Migration {
+	return func(m map[string]interface{}) error {
+		v, exist := m[key]
+		if !exist {
+			return nil
+		}
+		converted, err := convert(v)
+		if err != nil {
+			return fmt.Errorf("pref: cannot convert key %s: %v", key, err)
+		}
+		if converted == nil {
+			delete(m, key)
+		} else {
+			m[key] = converted
+		}
+		return nil
+	}
+}
+
+// migrate upgrades the key-values just loaded from storage to the current version, and saves them if they
+// have been upgraded. It returns the key-values to be used by the Preferences.
+func (p *PreferencesImpl) migrate(m map[string]interface{}) map[string]interface{} {
+	if len(p.migrations) == 0 {
+		return m
+	}
+	current := len(p.migrations) + 1
+	version := 1
+	if v, ok := m[versionKey].(int); ok {
+		version = v
+	}
+	if version > current {
+		log.Printf("The preference %s has version %d newer than %d", p.name, version, current)
+		return m
+	}
+	if version == current {
+		return m
+	}
+	// Migrate a copy, so the key-values are kept as they are if any migration fails.
+	migrated := make(map[string]interface{})
+	for k, v := range m {
+		migrated[k] = v
+	}
+	for i := version - 1; i < len(p.migrations); i++ {
+		if err := p.migrations[i](migrated); err != nil {
+			log.Printf("Error when migrate the preference %s to version %d: %v", p.name, i+2, err)
+			return m
+		}
+	}
+	migrated[versionKey] = current
+	if err := p.storage.Save(migrated, replaceRecords(migrated)); err != nil {
+		log.Printf("Error when save the migrated preference %s: %v", p.name, err)
+	}
+	return migrated
+}
+
+// isReserved returns whether the key is reserved for the Preferences itself.
+func isReserved(key string) bool {
+	return strings.HasPrefix(key, reservedPrefix)
+}
@@ -0,0 +1,107 @@
+package pref
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"strconv"
+	"testing"
+)
+
+type MigrationTestSuite struct {
+	suite.Suite
+}
+
+func (suite *MigrationTestSuite) SetupTest() {
+	basePath = "./"
+}
+
+func (suite *MigrationTestSuite) TearDownTest() {
+	os.Remove(basePath + PrefName)
+	os.Remove(basePath + PrefName + "_bak")
+}
+
+func TestMigrationTestSuite(t *testing.T) {
+	suite.Run(t, new(MigrationTestSuite))
+}
+
+func (suite *MigrationTestSuite) load(migrations ...Migration) *PreferencesImpl {
+	p := newPreferencesImpl(PrefName)
+	WithMigrations(migrations...)(p)
+	p.loadWg.Add(1)
+	go p.loadFromFile()
+	p.loadWg.Wait()
+	return p
+}
+
+func parseTimeout(v interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, errors.New("not a string")
+	}
+	return strconv.Atoi(s)
+}
+
+func (suite *MigrationTestSuite) TestMigrate() {
+	newFileStorage(basePath+PrefName).Save(map[string]interface{}{"net_timeout": "30", "legacy": true, "name": "pref"}, nil)
+	migrations := []Migration{
+		RenameKey("net_timeout", "net.timeout"),
+		ConvertKey("net.timeout", parseTimeout),
+		RemoveKeys("legacy"),
+	}
+	p := suite.load(migrations...)
+	suite.Equal(p.GetInt("net.timeout", 0), 30)
+	suite.False(p.Contains("legacy"))
+	suite.Equal(p.GetString("name", ""), "pref")
+	suite.Equal(p.values()[versionKey], 4)
+
+	// The migrations do not run again.
+	p.Edit().Put("net_timeout", "10").Commit()
+	p = suite.load(migrations...)
+	suite.Equal(p.GetString("net_timeout", ""), "10")
+	suite.Equal(p.GetInt("net.timeout", 0), 30)
+}
+
+func (suite *MigrationTestSuite) TestMigrateOnReload() {
+	p := suite.load(RenameKey("net_timeout", "net.timeout"), ConvertKey("net.timeout", parseTimeout))
+	ch := make(chan string, 2)
+	p.RegisterOnPreferenceChangeListener(ch)
+	newFileStorage(basePath+PrefName).Save(map[string]interface{}{"net_timeout": "30"}, nil)
+	suite.True(p.Reload())
+	suite.Equal("net.timeout", <-ch)
+	suite.Len(ch, 0)
+	suite.Equal(map[string]interface{}{"net.timeout": 30}, p.GetAll())
+	suite.Equal(p.values()[versionKey], 3)
+	suite.Equal(load(PrefName).values(), p.values())
+}
+
+func (suite *MigrationTestSuite) TestMigrateFromVersion() {
+	p := suite.load(RenameKey("a", "b"))
+	p.Edit().Put("b", 1).Put("c", "2").Commit()
+	p = suite.load(RenameKey("a", "b"), ConvertKey("c", parseTimeout))
+	suite.Equal(p.GetInt("b", 0), 1)
+	suite.Equal(p.GetInt("c", 0), 2)
+	suite.Equal(p.values()[versionKey], 3)
+}
+
+func (suite *MigrationTestSuite) TestMigrationFailed() {
+	newFileStorage(basePath+PrefName).Save(map[string]interface{}{"timeout": "abc", "other": 1}, nil)
+	p := suite.load(RenameKey("other", "renamed"), ConvertKey("timeout", parseTimeout))
+	suite.Equal(p.GetString("timeout", ""), "abc")
+	suite.Equal(p.GetInt("other", 0), 1)
+	suite.False(p.Contains(versionKey))
+}
+
+func (suite *MigrationTestSuite) TestClearKeepsVersion() {
+	p := suite.load(RenameKey("a", "b"))
+	p.Edit().Put("b", 1).Commit()
+	p.Edit().Clear().Commit()
+	suite.Equal(p.values(), map[string]interface{}{versionKey: 2})
+	p = suite.load(RenameKey("a", "b"))
+	suite.Equal(p.values(), map[string]interface{}{versionKey: 2})
+}
+
+func (suite *MigrationTestSuite) TestNoMigrations() {
+	newFileStorage(basePath+PrefName).Save(map[string]interface{}{"key": 1}, nil)
+	suite.Equal(suite.load().values(), map[string]interface{}{"key": 1})
+}
@@ -1,8 +1,11 @@
 package pref
 
+import "time"
+
 type OnPreferenceChangeListener chan string
 
-type Preferences interface {
+// Reader reads the key-values of a Preferences.
+type Reader interface {
 	Contains(string) bool
 	GetBool(string, bool) bool
 	GetInt(string, int) int
@@ -16,10 +19,21 @@ type Preferences interface {
 	GetRune(string, rune) rune
 	GetString(string, string) string
 	GetObject(string, interface{}) interface{}
+}
+
+type Preferences interface {
+	Reader
 	RegisterOnPreferenceChangeListener(OnPreferenceChangeListener)
 	UnregisterOnPreferenceChangeListener(OnPreferenceChangeListener)
+	Revision() uint64
+	GetAll() map[string]interface{}
+	SetDefaults(map[string]interface{})
+	GetOrDefault(string) interface{}
+	IsDefault(string) bool
 
 	Edit() Editor
+	EditAt(uint64) Editor
+	Update(func(Tx) error) error
 }
 
 type Editor interface {
@@ -28,4 +42,16 @@ type Editor interface {
 	Clear() Editor
 	Remove(string) Editor
 	Put(string, interface{}) Editor
+	CompareAndPut(string, interface{}, interface{}) Editor
+	Increment(string, int64) Editor
+	PutWithTTL(string, interface{}, time.Duration) Editor
+	Err() error
+}
+
+// Tx is a transaction of Preferences.Update, its reads see its own writes which are not committed yet.
+type Tx interface {
+	Reader
+	Clear() Tx
+	Remove(string) Tx
+	Put(string, interface{}) Tx
 }
@@ -2,11 +2,17 @@ package pref
 
 import (
 	"concurrent"
-	"encoding/gob"
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
 	"log"
-	"os"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -22,8 +28,37 @@ var (
 
 // PreferencesImpl is a basic struct for store/access data to/from memory and storage.
 type PreferencesImpl struct {
-	m            map[string]interface{}
-	name         string
+	// TypedGetters implements the typed getters on top of GetObject.
+	TypedGetters
+	// snapshot holds the current *state. A stored state is never modified again, writers publish a
+	// modified copy instead, so readers can access it without holding any lock.
+	snapshot atomic.Value
+	name     string
+	epoch    string
+	storage  Storage
+	// schema holds the *Schema which validates the changes, or a nil *Schema.
+	schema atomic.Value
+	// migrations upgrade the key-values from the version stored with them, see WithMigrations.
+	migrations []Migration
+	// defaults holds the map[string]interface{} of registered default values, it is replaced as a whole.
+	defaults atomic.Value
+	// now returns the current time to expire the keys put by PutWithTTL.
+	now func() time.Time
+	// journalDir is the directory of the journals of Batch, which is captured when the Preferences is
+	// created since basePath may be changed while it is being loaded.
+	journalDir string
+	// storageBehind means the last save failed, so the storage misses some changes in memory. It is
+	// guarded by diskLock.
+	storageBehind bool
+	// journals are the journals of the batches which keep the records of this Preferences, since they
+	// failed to be saved. It is guarded by diskLock.
+	journals []string
+	// forwarders keeps the stop functions of the goroutines which forward the events of a namespace to the
+	// listeners registered through Sub. It is guarded by forwardLock.
+	forwarders  map[subListener]func()
+	forwardLock *sync.Mutex
+	// replica keeps the clock of the replication enabled by WithReplica, or nil.
+	replica      *replica
 	observers    map[chan string]interface{}
 	writeCh      chan map[string]interface{}
 	diskLock     *sync.Mutex
@@ -32,34 +67,122 @@ type PreferencesImpl struct {
 	*sync.Mutex
 }
 
+// state is an immutable version of the key-values of a Preferences.
+type state struct {
+	m map[string]interface{}
+	// revision is increased by one whenever the key-values are changed.
+	revision uint64
+}
+
 // EditorImpl is a modifier of Preferences.
 type EditorImpl struct {
 	modified map[string]interface{}
-	pref     *PreferencesImpl
-	cleared  bool
+	// expected keeps the values which the keys must still have when the changes are committed, a nil value
+	// means the key must not exist.
+	expected map[string]interface{}
+	// increments keeps the deltas to be added to the values of keys when the changes are committed.
+	increments map[string]int64
+	pref       *PreferencesImpl
+	cleared    bool
+	// clearedPrefixes are the namespaces cleared by the editors of Sub, see clearPrefix.
+	clearedPrefixes []string
+	// revision is the revision the changes are based on, and it is only checked if atRevision is set.
+	revision   uint64
+	atRevision bool
+	// err is the reason why the last commit failed.
+	err error
+	// putErr is the error of the first invalid value put to editor, it fails every commit of editor.
+	putErr error
 	*sync.Mutex
 }
 
+// Option configures a Preferences when it is created by NewPreferences.
+type Option func(*PreferencesImpl)
+
+// WithStorage sets the storage of the Preferences instead of the default gob file.
+func WithStorage(storage Storage) Option {
+	return func(p *PreferencesImpl) {
+		p.storage = storage
+	}
+}
+
+// WithLogStorage stores the Preferences as a snapshot file with a write-ahead log, so that a commit only
+// appends its changed keys. The log is compacted into the snapshot when it grows past threshold bytes.
+func WithLogStorage(threshold int64) Option {
+	return func(p *PreferencesImpl) {
+		p.storage = newLogStorage(basePath+p.name, threshold)
+	}
+}
+
+// WithDirStorage stores every key of the Preferences as a file in the directory basePath/<name>/, the type
+// of a key is marked by a sidecar file, see dirStorage. Call Watch to pick up the changes made to the
+// files by other programs.
+func WithDirStorage() Option {
+	return func(p *PreferencesImpl) {
+		p.storage = newDirStorage(basePath + p.name)
+	}
+}
+
+// WithSchema validates the changes to the Preferences by the schema.
+func WithSchema(schema *Schema) Option {
+	return func(p *PreferencesImpl) {
+		p.SetSchema(schema)
+	}
+}
+
 // InitBasePath should be called before NewPreferences to initialize the default storage path.
 func InitBasePath(path string) {
 	basePath = path
 }
 
-// NewPreferences gets or creates an instance of Preferences with a given name, remember to call gob.Register
-// before writing/reading custom types to/from a Preferences.
-func NewPreferences(name string) Preferences {
+// Names returns the names of the Preferences stored under the base path by any of the storages, and of the
+// ones opened by NewPreferences but not committed yet, sorted in order.
+func Names() ([]string, error) {
+	infos, err := ioutil.ReadDir(basePath)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, info := range infos {
+		name := info.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, journalSuffix) || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		// The directories are stored by the dir storage, and the files by the others with their suffixes.
+		if !info.IsDir() {
+			for _, suffix := range []string{"_bak", ".log", legacySuffix} {
+				name = strings.TrimSuffix(name, suffix)
+			}
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	prefLock.Lock()
+	for name := range prefMap {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	prefLock.Unlock()
+	sort.Strings(names)
+	return names, nil
+}
+
+// NewPreferences gets or creates an instance of Preferences with a given name. The custom types put by an
+// editor are registered to gob automatically, pass WithTypes to register the custom types stored by earlier
+// runs before they are loaded, the values of unregistered types are kept as RawValue. The options only take
+// effect when the Preferences is created.
+func NewPreferences(name string, options ...Option) Preferences {
 	prefLock.Lock()
 	defer prefLock.Unlock()
 	if _, exist := prefMap[name]; !exist {
-		pref := &PreferencesImpl{
-			m:            make(map[string]interface{}),
-			name:         name,
-			observers:    make(map[chan string]interface{}),
-			writeCh:      make(chan map[string]interface{}, 10),
-			diskLock:     &sync.Mutex{},
-			observerLock: &sync.Mutex{},
-			loadWg:       &sync.WaitGroup{},
-			Mutex:        &sync.Mutex{}}
+		pref := newPreferencesImpl(name)
+		for _, option := range options {
+			option(pref)
+		}
 		pref.loadWg.Add(1)
 		go pref.loadFromFile()
 		prefMap[name] = pref
@@ -67,28 +190,181 @@ func NewPreferences(name string) Preferences {
 	return prefMap[name]
 }
 
+// Release removes the Preferences with a given name from the instances kept by NewPreferences once its
+// pending writes have completed, so the next NewPreferences of the name loads it again, such as from another
+// base path. The released instance can still be used, but it is no longer shared.
+func Release(name string) {
+	prefLock.Lock()
+	p, exist := prefMap[name]
+	delete(prefMap, name)
+	prefLock.Unlock()
+	if !exist {
+		return
+	}
+	p.loadWg.Wait()
+	// The executor runs the writes in order, so the ones submitted before have completed when it runs.
+	done := make(chan struct{})
+	executor.Execute(func() {
+		close(done)
+	})
+	<-done
+}
+
+func newPreferencesImpl(name string) *PreferencesImpl {
+	pref := &PreferencesImpl{
+		name:         name,
+		epoch:        newEpoch(),
+		storage:      newFileStorage(basePath + name),
+		journalDir:   basePath,
+		now:          time.Now,
+		observers:    make(map[chan string]interface{}),
+		forwarders:   make(map[subListener]func()),
+		forwardLock:  &sync.Mutex{},
+		writeCh:      make(chan map[string]interface{}, 10),
+		diskLock:     &sync.Mutex{},
+		observerLock: &sync.Mutex{},
+		loadWg:       &sync.WaitGroup{},
+		Mutex:        &sync.Mutex{}}
+	pref.TypedGetters = TypedGetters{pref.GetObject}
+	pref.snapshot.Store(&state{m: make(map[string]interface{})})
+	pref.schema.Store((*Schema)(nil))
+	pref.defaults.Store(make(map[string]interface{}))
+	return pref
+}
+
+// current returns the current state of the key-values, which must not be modified by the caller.
+func (p *PreferencesImpl) current() *state {
+	return p.snapshot.Load().(*state)
+}
+
+// values returns the current snapshot of key-values, which must not be modified by the caller.
+func (p *PreferencesImpl) values() map[string]interface{} {
+	return p.current().m
+}
+
+// publishLocked replaces the key-values with m as a new revision.
+func (p *PreferencesImpl) publishLocked(m map[string]interface{}) {
+	p.snapshot.Store(&state{m: m, revision: p.current().revision + 1})
+}
+
 func (p *PreferencesImpl) loadFromFile() {
 	p.Lock()
 	defer p.Unlock()
-	path := basePath + p.name
-	backupPath := path + "_bak"
-	// Load backup file if exists.
-	if _, err := os.Stat(backupPath); err == nil {
-		os.Remove(path)
-		os.Rename(backupPath, path)
-	}
-	if file, err := os.Open(basePath + p.name); err == nil {
-		defer file.Close()
-		dec := gob.NewDecoder(file)
-		if err := dec.Decode(&p.m); err != nil {
-			log.Printf("Error when decode the preference: %v", err)
-		}
-	} else if os.IsExist(err) {
-		log.Printf("Error reading the preference file %s", basePath+p.name)
+	p.diskLock.Lock()
+	defer p.diskLock.Unlock()
+	if m, err := p.storage.Load(); err == nil {
+		p.recoverJournals(m)
+		p.snapshot.Store(&state{m: p.migrate(m)})
+	} else {
+		log.Printf("Error when load the preference %s: %v", p.name, err)
 	}
 	p.loadWg.Done()
 }
 
+// SetSchema sets the schema which validates the changes made by editors, a nil schema disables the
+// validation. The values already stored are not checked.
+func (p *PreferencesImpl) SetSchema(schema *Schema) {
+	p.schema.Store(schema)
+}
+
+// validate checks the value of a key by the schema if any.
+func (p *PreferencesImpl) validate(key string, value interface{}) error {
+	if schema := p.schema.Load().(*Schema); schema != nil {
+		return schema.Validate(key, value)
+	}
+	return nil
+}
+
+// Reload reads the key-values from storage again, and notifies the listeners of the keys which have been
+// changed outside of this Preferences. The storage is read after the pending writes of Apply are done.
+func (p *PreferencesImpl) Reload() bool {
+	p.loadWg.Wait()
+	p.Lock()
+	defer p.Unlock()
+	result := make(chan map[string]interface{}, 1)
+	executor.Execute(func() {
+		p.diskLock.Lock()
+		defer p.diskLock.Unlock()
+		m, err := p.storage.Load()
+		if err != nil {
+			log.Printf("Error when reload the preference %s: %v", p.name, err)
+		} else {
+			// The key-values written by an older version are upgraded as they are on the first load.
+			m = p.migrate(m)
+		}
+		result <- m
+	})
+	m := <-result
+	if m == nil {
+		return false
+	}
+	// The reserved keys are published with the others, but the listeners are not notified of them.
+	if reflect.DeepEqual(p.values(), m) {
+		return true
+	}
+	keys := changedKeys(p.values(), m)
+	p.publishLocked(m)
+	if len(keys) > 0 {
+		p.notifyObservers(keys)
+	}
+	return true
+}
+
+// Watch reloads the Preferences periodically when its storage has been modified by others, until the
+// returned stop function is called. A storage which cannot detect its modifications is reloaded on
+// every interval.
+func (p *PreferencesImpl) Watch(interval time.Duration) (stop func()) {
+	done := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if p.storageChanged() {
+					p.Reload()
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+}
+
+func (p *PreferencesImpl) storageChanged() bool {
+	detector, ok := p.storage.(interface {
+		Changed() bool
+	})
+	if !ok {
+		return true
+	}
+	p.diskLock.Lock()
+	defer p.diskLock.Unlock()
+	return detector.Changed()
+}
+
+// changedKeys returns the keys whose values are different between two maps, except the reserved keys.
+func changedKeys(old, new map[string]interface{}) []string {
+	keys := make([]string, 0)
+	for k, v := range new {
+		if o, exist := old[k]; !isReserved(k) && (!exist || !reflect.DeepEqual(o, v)) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range old {
+		if _, exist := new[k]; !isReserved(k) && !exist {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
 // RegisterOnPreferenceChangeListener registers a listener for listening the changes of a preference.
 func (p *PreferencesImpl) RegisterOnPreferenceChangeListener(observer OnPreferenceChangeListener) {
 	p.observerLock.Lock()
@@ -109,149 +385,164 @@ func (p *PreferencesImpl) UnregisterOnPreferenceChangeListener(observer OnPrefer
 
 // Contains returns whether a key exists in this preference.
 func (p *PreferencesImpl) Contains(key string) bool {
-	p.Lock()
-	defer p.Unlock()
 	p.loadWg.Wait()
-	_, exist := p.m[key]
+	_, exist := p.lookup(p.values(), key)
 	return exist
 }
 
-// GetBool returns the bool value from memory, and return default value if the key has not been set.
-func (p *PreferencesImpl) GetBool(key string, defaultValue bool) bool {
-	val, ok := p.GetObject(key, defaultValue).(bool)
-	if !ok {
+// GetObject returns the object value from memory, and return default value if the key has not been set.
+// The default value registered by SetDefaults takes precedence over the given one.
+func (p *PreferencesImpl) GetObject(key string, defaultValue interface{}) interface{} {
+	p.loadWg.Wait()
+	obj, exist := p.lookup(p.values(), key)
+	if !exist {
+		if registered, exist := p.defaultValue(key); exist {
+			return registered
+		}
 		return defaultValue
 	}
-	return val
-}
-
-// GetInt returns the int value from memory, and return default value if the key has not been set.
-func (p *PreferencesImpl) GetInt(key string, defaultValue int) int {
-	val, ok := p.GetObject(key, defaultValue).(int)
-	if !ok {
-		return defaultValue
+	if obj, ok := resolve(obj); ok {
+		return obj
 	}
-	return val
+	return defaultValue
 }
 
-// GetInt32 returns the int32 value from memory, and return default value if the key has not been set.
-func (p *PreferencesImpl) GetInt32(key string, defaultValue int32) int32 {
-	val, ok := p.GetObject(key, defaultValue).(int32)
-	if !ok {
-		return defaultValue
-	}
-	return val
+// GetAll returns a copy of all the key-values which have been set, the reserved and expired keys are
+// excluded, and the registered defaults are not included.
+func (p *PreferencesImpl) GetAll() map[string]interface{} {
+	p.loadWg.Wait()
+	return p.visible(p.values())
 }
 
-// GetInt64 returns the int64 value from memory, and return default value if the key has not been set.
-func (p *PreferencesImpl) GetInt64(key string, defaultValue int64) int64 {
-	val, ok := p.GetObject(key, defaultValue).(int64)
-	if !ok {
-		return defaultValue
+// visible returns a copy of the key-values in m without the reserved and expired keys.
+func (p *PreferencesImpl) visible(m map[string]interface{}) map[string]interface{} {
+	all := make(map[string]interface{}, len(m))
+	for k := range m {
+		if isReserved(k) {
+			continue
+		}
+		if v, exist := p.lookup(m, k); exist {
+			all[k] = v
+		}
 	}
-	return val
+	return all
 }
 
-// GetUInt32 returns the uint32 value from memory, and return default value if the key has not been set.
-func (p *PreferencesImpl) GetUInt32(key string, defaultValue uint32) uint32 {
-	val, ok := p.GetObject(key, defaultValue).(uint32)
-	if !ok {
-		return defaultValue
-	}
-	return val
+// Edit creates an editor to modify the value of Preferences.
+func (p *PreferencesImpl) Edit() Editor {
+	p.loadWg.Wait()
+	return newEditorImpl(p)
 }
 
-// GetUInt64 returns the uint64 value from memory, and return default value if the key has not been set.
-func (p *PreferencesImpl) GetUInt64(key string, defaultValue uint64) uint64 {
-	val, ok := p.GetObject(key, defaultValue).(uint64)
-	if !ok {
-		return defaultValue
-	}
-	return val
+// EditAt creates an editor whose changes are based on the given revision, its commit fails with
+// ErrConflict if the Preferences has been changed since then. After a successful commit the editor is
+// based on the revision of its own changes.
+func (p *PreferencesImpl) EditAt(revision uint64) Editor {
+	p.loadWg.Wait()
+	e := newEditorImpl(p)
+	e.revision = revision
+	e.atRevision = true
+	return e
 }
 
-// GetFloat32 returns the float32 value from memory, and return default value if the key has not been set.
-func (p *PreferencesImpl) GetFloat32(key string, defaultValue float32) float32 {
-	val, ok := p.GetObject(key, defaultValue).(float32)
-	if !ok {
-		return defaultValue
-	}
-	return val
+// Revision returns the current revision of the Preferences, which is increased whenever its key-values
+// are changed. It starts from zero every time the Preferences is loaded.
+func (p *PreferencesImpl) Revision() uint64 {
+	p.loadWg.Wait()
+	return p.current().revision
 }
 
-// GetFloat64 returns the float64 value from memory, and return default value if the key has not been set.
-func (p *PreferencesImpl) GetFloat64(key string, defaultValue float64) float64 {
-	val, ok := p.GetObject(key, defaultValue).(float64)
-	if !ok {
-		return defaultValue
-	}
-	return val
+// Epoch returns the identifier chosen randomly when this instance of the Preferences is created. The
+// revisions start again in every instance, so a revision is only meaningful with its epoch.
+func (p *PreferencesImpl) Epoch() string {
+	return p.epoch
 }
 
-// GetByte returns the byte value from memory, and return default value if the key has not been set.
-func (p *PreferencesImpl) GetByte(key string, defaultValue byte) byte {
-	val, ok := p.GetObject(key, defaultValue).(byte)
-	if !ok {
-		return defaultValue
+func newEpoch() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
 	}
-	return val
+	return hex.EncodeToString(b)
 }
 
-// GetRune returns the rune value from memory, and return default value if the key has not been set.
-func (p *PreferencesImpl) GetRune(key string, defaultValue rune) rune {
-	val, ok := p.GetObject(key, defaultValue).(rune)
-	if !ok {
-		return defaultValue
+func newEditorImpl(p *PreferencesImpl) *EditorImpl {
+	return &EditorImpl{
+		modified:   make(map[string]interface{}),
+		expected:   make(map[string]interface{}),
+		increments: make(map[string]int64),
+		pref:       p,
+		cleared:    false,
+		Mutex:      &sync.Mutex{},
 	}
-	return val
 }
 
-// GetString returns the string value from memory, and return default value if the key has not been set.
-func (p *PreferencesImpl) GetString(key string, defaultValue string) string {
-	val, ok := p.GetObject(key, defaultValue).(string)
-	if !ok {
-		return defaultValue
+// Put sets the modified object value in editor. An invalid value by the schema is not put, and it fails
+// every commit of editor with the *ValidationError.
+func (e *EditorImpl) Put(key string, value interface{}) Editor {
+	e.Lock()
+	defer e.Unlock()
+	if !e.validateLocked(key, value) {
+		return e
 	}
-	return val
+	registerType(value)
+	e.modified[key] = value
+	delete(e.increments, key)
+	return e
 }
 
-// GetObject returns the object value from memory, and return default value if the key has not been set.
-func (p *PreferencesImpl) GetObject(key string, defaultValue interface{}) interface{} {
-	p.loadWg.Wait()
-	p.Lock()
-	defer p.Unlock()
-	obj, exist := p.m[key]
-	if !exist {
-		return defaultValue
-	}
-	return obj
+// Remove sets the nil value in editor.
+func (e *EditorImpl) Remove(key string) Editor {
+	e.Lock()
+	defer e.Unlock()
+	e.modified[key] = nil
+	delete(e.increments, key)
+	return e
 }
 
-// Edit creates an editor to modify the value of Preferences.
-func (p *PreferencesImpl) Edit() Editor {
-	p.loadWg.Wait()
-	return &EditorImpl{
-		modified: make(map[string]interface{}),
-		pref:     p,
-		cleared:  false,
-		Mutex:    &sync.Mutex{},
+// CompareAndPut sets the value of key only if its current value is still expected when the changes are
+// committed, a nil expected value means the key must not exist. If any condition fails, none of the
+// changes in editor is committed and Err returns a *ConditionError.
+func (e *EditorImpl) CompareAndPut(key string, expected interface{}, value interface{}) Editor {
+	e.Lock()
+	defer e.Unlock()
+	if !e.validateLocked(key, value) {
+		return e
 	}
+	registerType(value)
+	e.expected[key] = expected
+	e.modified[key] = value
+	delete(e.increments, key)
+	return e
 }
 
-// Put sets the modified object value in editor.
-func (e *EditorImpl) Put(key string, value interface{}) Editor {
+// Increment adds delta to the numeric value of key atomically when the changes are committed, a key
+// which does not exist is set to int(delta). The commit fails as a condition if the value is not a
+// number.
+func (e *EditorImpl) Increment(key string, delta int64) Editor {
 	e.Lock()
 	defer e.Unlock()
-	e.modified[key] = value
+	e.increments[key] += delta
 	return e
 }
 
-// Remove sets the nil value in editor.
-func (e *EditorImpl) Remove(key string) Editor {
+// validateLocked checks the value by the schema, and keeps the first error in putErr.
+func (e *EditorImpl) validateLocked(key string, value interface{}) bool {
+	if err := e.pref.validate(key, value); err != nil {
+		if e.putErr == nil {
+			e.putErr = err
+		}
+		return false
+	}
+	return true
+}
+
+// Err returns the reason why the last Apply or Commit failed, or nil if it succeeded. The failure of the
+// disk write of Apply is not reported since it happens later.
+func (e *EditorImpl) Err() error {
 	e.Lock()
 	defer e.Unlock()
-	e.modified[key] = nil
-	return e
+	return e.err
 }
 
 // Clear the whole key-values in the preference.
@@ -262,9 +553,30 @@ func (e *EditorImpl) Clear() Editor {
 	return e
 }
 
+// clearPrefix removes the keys with the prefix when the changes are committed, like Clear but only for the
+// namespace of a Sub.
+func (e *EditorImpl) clearPrefix(prefix string) {
+	e.Lock()
+	defer e.Unlock()
+	e.clearedPrefixes = append(e.clearedPrefixes, prefix)
+}
+
+// clearedKey returns whether key is removed by Clear or by the clear of its namespace.
+func (e *EditorImpl) clearedKey(key string) bool {
+	if e.cleared {
+		return true
+	}
+	for _, prefix := range e.clearedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *PreferencesImpl) copyOfMapLocked() map[string]interface{} {
 	dst := make(map[string]interface{})
-	for k, v := range p.m {
+	for k, v := range p.values() {
 		dst[k] = v
 	}
 	return dst
@@ -276,64 +588,223 @@ func (e *EditorImpl) Apply() {
 	defer e.Unlock()
 	e.pref.Lock()
 	defer e.pref.Unlock()
-	keys := e.commitToMemoryLocked()
+	keys, records := e.commitToMemoryLocked()
 	if len(keys) > 0 {
+		snapshot := e.pref.values()
 		executor.Execute(func() {
-			e.pref.commitToDisk(e.pref.copyOfMapLocked())
+			e.pref.commitToDisk(snapshot, records)
 		})
 		e.pref.notifyObservers(keys)
 	}
 }
 
-// Commit submits the changes to memory and disk synchronously.
+// Commit submits the changes to memory and disk synchronously. The in-memory lock is released before
+// the disk write, and the write is queued after the pending writes of Apply so that an older snapshot
+// never overwrites a newer one. Commit returns after its own changes have been written.
 func (e *EditorImpl) Commit() bool {
 	e.Lock()
 	defer e.Unlock()
 	e.pref.Lock()
-	defer e.pref.Unlock()
-	var success = true
-	keys := e.commitToMemoryLocked()
-	if len(keys) > 0 {
-		success = e.pref.commitToDisk(e.pref.m)
-		e.pref.notifyObservers(keys)
+	return e.commitAndUnlock()
+}
+
+// commitAndUnlock commits the changes while holding the pref lock, and waits for them to be written to
+// disk after releasing the lock.
+func (e *EditorImpl) commitAndUnlock() bool {
+	keys, records := e.commitToMemoryLocked()
+	if len(keys) == 0 {
+		e.pref.Unlock()
+		return e.err == nil
 	}
-	return success
+	snapshot := e.pref.values()
+	result := make(chan error, 1)
+	executor.Execute(func() {
+		result <- e.pref.commitToDisk(snapshot, records)
+	})
+	e.pref.notifyObservers(keys)
+	e.pref.Unlock()
+	e.err = <-result
+	return e.err == nil
 }
 
-func (e *EditorImpl) commitToMemoryLocked() []string {
+// commitToMemoryLocked publishes the changes of editor to memory, and returns the changed keys with the
+// records to be persisted by the storage.
+func (e *EditorImpl) commitToMemoryLocked() ([]string, []Record) {
+	m, keys, records := e.prepareLocked()
+	if e.err != nil {
+		return nil, nil
+	}
+	e.publishLocked(m, keys)
+	return keys, records
+}
+
+// prepareLocked applies the changes of editor to a copy of the key-values without publishing it, and
+// returns the copy with the changed keys and records. It sets e.err if the conditions of the changes fail.
+func (e *EditorImpl) prepareLocked() (map[string]interface{}, []string, []Record) {
+	// The conditions and increments only apply to one commit.
+	defer func() {
+		e.expected = make(map[string]interface{})
+		e.increments = make(map[string]int64)
+	}()
+	e.err = e.putErr
+	if e.err != nil {
+		return nil, nil, nil
+	}
+	if e.atRevision && e.revision != e.pref.current().revision {
+		e.err = ErrConflict
+		return nil, nil, nil
+	}
+	if e.err = e.resolveConditionsLocked(); e.err != nil {
+		return nil, nil, nil
+	}
+	records := make([]Record, 0)
 	// if clear flag is set, re-create a new modified map with all the keys in origin preference map,
 	// and set the values of all keys to nil, then put the origin modified map to this new map.
 	if e.cleared {
 		newModified := e.pref.copyOfMapLocked()
 		for k, _ := range newModified {
-			newModified[k] = nil
+			// The reserved keys such as the version are kept, and put again after the clear record.
+			if !isReserved(k) {
+				newModified[k] = nil
+			}
 		}
 		for k, v := range e.modified {
 			newModified[k] = v
 		}
 		e.modified = newModified
+		records = append(records, Record{Op: OpClear})
 	}
+	// A namespace cleared by a Sub only removes its own keys, the changes of editor are kept as Clear does.
+	for _, prefix := range e.clearedPrefixes {
+		for k := range e.pref.values() {
+			if _, exist := e.modified[k]; !exist && strings.HasPrefix(k, prefix) {
+				e.modified[k] = nil
+			}
+		}
+	}
+	// Readers may still hold the current snapshot, so apply the changes to a copy and publish it afterwards.
+	m := e.pref.copyOfMapLocked()
 	changedKeys := make([]string, 0)
+	// The expired keys are purged as if they were removed, unless they are put again by editor.
+	for _, k := range e.pref.expiredKeys(m) {
+		if _, exist := e.modified[k]; !exist {
+			delete(m, k)
+			changedKeys = append(changedKeys, k)
+			if !e.cleared {
+				records = append(records, Record{Op: OpRemove, Key: k})
+			}
+		}
+	}
 	for k, v := range e.modified {
-		old, exist := e.pref.m[k]
+		old, exist := m[k]
 		// A nil value in modified map indicates the Preferences shall be removed.
 		if v == nil {
 			if exist {
-				delete(e.pref.m, k)
+				delete(m, k)
 				changedKeys = append(changedKeys, k)
+				if !e.cleared {
+					records = append(records, Record{Op: OpRemove, Key: k})
+				}
+			}
+		} else {
+			changed := !reflect.DeepEqual(old, v)
+			if changed {
+				m[k] = v
+				changedKeys = append(changedKeys, k)
+			}
+			// After a clear record every remaining key has to be put again.
+			if changed || e.cleared {
+				records = append(records, Record{Op: OpPut, Key: k, Value: v})
 			}
-		} else if !reflect.DeepEqual(old, v) {
-			e.pref.m[k] = v
-			changedKeys = append(changedKeys, k)
 		}
 	}
-	return changedKeys
+	return m, changedKeys, e.pref.stampLocked(m, changedKeys, e.cleared, records)
+}
+
+// publishLocked publishes the key-values prepared by prepareLocked if any key has been changed.
+func (e *EditorImpl) publishLocked(m map[string]interface{}, keys []string) {
+	if len(keys) > 0 {
+		e.pref.publishLocked(m)
+	}
+	e.revision = e.pref.current().revision
+}
+
+// resolveConditionsLocked checks the expected values against the current ones, and puts the results of
+// increments to the modified map. It returns a *ConditionError with the keys whose conditions failed, or
+// a *ValidationError if a result of increments is invalid by the schema.
+func (e *EditorImpl) resolveConditionsLocked() error {
+	current := e.pref.values()
+	failed := make([]string, 0)
+	for k, expected := range e.expected {
+		v, exist := e.pref.lookup(current, k)
+		if expected == nil && exist || expected != nil && !reflect.DeepEqual(v, expected) {
+			failed = append(failed, k)
+		}
+	}
+	results := make(map[string]interface{})
+	// deadlines keeps the deadlines of the keys put by PutWithTTL, which are kept by their results.
+	deadlines := make(map[string]time.Time)
+	for k, delta := range e.increments {
+		// Increment the value put in this editor if any, otherwise the current one.
+		stored, exist := e.modified[k]
+		if !exist && !e.clearedKey(k) {
+			stored, exist = current[k]
+		}
+		var v interface{}
+		if exist {
+			v, exist = e.pref.unwrap(stored)
+		}
+		if result, ok := addDelta(v, delta); ok {
+			results[k] = result
+			if ev, expiring := stored.(expiringValue); expiring && exist {
+				deadlines[k] = ev.Deadline
+			}
+		} else {
+			failed = append(failed, k)
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return &ConditionError{Keys: failed}
+	}
+	for k, v := range results {
+		if err := e.pref.validate(k, v); err != nil {
+			return err
+		}
+	}
+	for k, v := range results {
+		if deadline, exist := deadlines[k]; exist {
+			v = expiringValue{Value: v, Deadline: deadline}
+		}
+		e.modified[k] = v
+	}
+	return nil
+}
+
+// addDelta adds delta to a numeric value and keeps its type, a nil value is treated as int(0).
+func addDelta(v interface{}, delta int64) (interface{}, bool) {
+	if v == nil {
+		return int(delta), true
+	}
+	rv := reflect.ValueOf(v)
+	result := reflect.New(rv.Type()).Elem()
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		result.SetInt(rv.Int() + delta)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		result.SetUint(rv.Uint() + uint64(delta))
+	case reflect.Float32, reflect.Float64:
+		result.SetFloat(rv.Float() + float64(delta))
+	default:
+		return nil, false
+	}
+	return result.Interface(), true
 }
 
 // notifyObservers send the changed keys to all registered observers.
 func (p *PreferencesImpl) notifyObservers(keys []string) {
 	p.observerLock.Lock()
-	p.observerLock.Unlock()
+	defer p.observerLock.Unlock()
 	for _, key := range keys {
 		for ob, _ := range p.observers {
 			select {
@@ -344,32 +815,25 @@ func (p *PreferencesImpl) notifyObservers(keys []string) {
 	}
 }
 
-func (p *PreferencesImpl) commitToDisk(changedMap map[string]interface{}) bool {
-	path := basePath + p.name
-	backupPath := path + "_bak"
+func (p *PreferencesImpl) commitToDisk(changedMap map[string]interface{}, records []Record) error {
 	p.diskLock.Lock()
 	defer p.diskLock.Unlock()
-	// Backup the normal file
-	if _, err := os.Stat(path); err == nil {
-		if _, err2 := os.Stat(backupPath); err2 == nil {
-			os.Remove(path)
-		} else {
-			os.Rename(path, backupPath)
-		}
+	if p.storageBehind {
+		// The records of the failed save are lost, so the key-values are saved as a whole.
+		records = replaceRecords(changedMap)
 	}
-	if file, err := os.Create(path); err == nil {
-		defer file.Close()
-		enc := gob.NewEncoder(file)
-		if err := enc.Encode(&changedMap); err != nil {
-			log.Printf("Error when write preference: %v", err)
-			// remove normal file if error
-			os.Remove(path)
-			return false
-		} else {
-			// remove backup file if success
-			os.Remove(backupPath)
-			return true
-		}
+	if len(p.journals) > 0 {
+		// The journals are written ahead of the save, so they can be replayed if it is interrupted.
+		p.updateJournals(records)
 	}
-	return false
+	err := p.storage.Save(changedMap, records)
+	p.storageBehind = err != nil
+	if err == nil && len(p.journals) > 0 {
+		p.updateJournals(nil)
+		p.journals = nil
+	}
+	if err != nil {
+		log.Printf("Error when write preference: %v", err)
+	}
+	return err
 }
@@ -1,10 +1,13 @@
 package pref
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/suite"
+	"io/ioutil"
 	"os"
-	"sync"
+	"runtime"
 	"testing"
+	"time"
 )
 
 const PrefName = "pref_unit"
@@ -19,22 +22,8 @@ type TestSuite struct {
 func (suite *TestSuite) SetupTest() {
 	basePath = "./"
 	prefMap = make(map[string]*PreferencesImpl)
-	pref = &PreferencesImpl{
-		m:            make(map[string]interface{}),
-		name:         PrefName,
-		observers:    make(map[chan string]interface{}),
-		writeCh:      make(chan map[string]interface{}),
-		diskLock:     &sync.Mutex{},
-		observerLock: &sync.Mutex{},
-		loadWg:       &sync.WaitGroup{},
-		Mutex:        &sync.Mutex{},
-	}
-	editor = &EditorImpl{
-		modified: make(map[string]interface{}),
-		pref:     pref,
-		cleared:  false,
-		Mutex:    &sync.Mutex{},
-	}
+	pref = newPreferencesImpl(PrefName)
+	editor = newEditorImpl(pref)
 }
 
 func (suite *TestSuite) TearDownTest() {
@@ -57,7 +46,7 @@ func (suite *TestSuite) TestGetSharedPreferenceEmpty() {
 	NewPreferences("name1")
 	suite.Len(prefMap, 1)
 	suite.Equal(prefMap["name1"].name, "name1")
-	suite.Empty(prefMap["name1"].m)
+	suite.Empty(prefMap["name1"].values())
 	suite.Len(prefMap["name1"].observers, 0)
 }
 
@@ -67,10 +56,23 @@ func (suite *TestSuite) TestGetSharedPreferenceExist() {
 	NewPreferences(PrefName)
 	suite.Len(prefMap, 1)
 	suite.Equal(prefMap[PrefName].name, PrefName)
-	suite.Empty(prefMap[PrefName].m)
+	suite.Empty(prefMap[PrefName].values())
 	suite.Len(prefMap[PrefName].observers, 0)
 }
 
+func (suite *TestSuite) TestRelease() {
+	p := NewPreferences(PrefName)
+	p.Edit().Put("key", "value").Apply()
+	Release(PrefName)
+	suite.Empty(prefMap)
+	Release(PrefName)
+
+	// The new instance loads the value applied to the released one.
+	other := NewPreferences(PrefName)
+	suite.NotEqual(p, other)
+	suite.Equal("value", other.GetString("key", ""))
+}
+
 func (suite *TestSuite) TestRegisterObserver() {
 	o := make(chan string)
 	suite.Len(pref.observers, 0)
@@ -128,18 +130,20 @@ func (suite *TestSuite) TestGet() {
 	var obj = struct {
 		field string
 	}{field: "str"}
-	pref.m["key1"] = boolean
-	pref.m["key2"] = i
-	pref.m["key3"] = i32
-	pref.m["key4"] = i64
-	pref.m["key5"] = u32
-	pref.m["key6"] = u64
-	pref.m["key7"] = f32
-	pref.m["key8"] = f64
-	pref.m["key9"] = b
-	pref.m["key10"] = r
-	pref.m["key11"] = str
-	pref.m["key12"] = obj
+	pref.publishLocked(map[string]interface{}{
+		"key1":  boolean,
+		"key2":  i,
+		"key3":  i32,
+		"key4":  i64,
+		"key5":  u32,
+		"key6":  u64,
+		"key7":  f32,
+		"key8":  f64,
+		"key9":  b,
+		"key10": r,
+		"key11": str,
+		"key12": obj,
+	})
 	suite.Equal(pref.GetBool("key1", false), boolean)
 	suite.Equal(pref.GetInt("key2", 0), i)
 	suite.Equal(pref.GetInt32("key3", 0), i32)
@@ -155,7 +159,7 @@ func (suite *TestSuite) TestGet() {
 }
 
 func (suite *TestSuite) TestContains() {
-	pref.m["key"] = 3
+	pref.publishLocked(map[string]interface{}{"key": 3})
 	suite.False(pref.Contains("other"))
 	suite.True(pref.Contains("key"))
 }
@@ -218,23 +222,131 @@ func (suite *TestSuite) TestClear() {
 }
 
 func (suite *TestSuite) TestApply() {
-	suite.Empty(pref.m)
+	suite.Empty(pref.values())
 	editor.Put("key", 5).Apply()
-	suite.Equal(pref.m["key"], 5)
+	suite.Equal(pref.values()["key"], 5)
 	editor.Put("key", 15).Apply()
-	suite.Equal(pref.m["key"], 15)
+	suite.Equal(pref.values()["key"], 15)
 	editor.Remove("key").Apply()
-	suite.Empty(pref.m)
+	suite.Empty(pref.values())
 }
 
 func (suite *TestSuite) TestCommit() {
-	suite.Empty(pref.m)
+	suite.Empty(pref.values())
 	editor.Put("key", 5).Commit()
-	suite.Equal(pref.m["key"], 5)
+	suite.Equal(pref.values()["key"], 5)
 	editor.Put("key", 15).Commit()
-	suite.Equal(pref.m["key"], 15)
+	suite.Equal(pref.values()["key"], 15)
 	editor.Remove("key").Commit()
-	suite.Empty(pref.m)
+	suite.Empty(pref.values())
+}
+
+func (suite *TestSuite) TestCommitDoesNotBlockReaders() {
+	ch := make(chan bool)
+	pref.diskLock.Lock()
+	go func() {
+		ch <- editor.Put("key", 5).Commit()
+	}()
+	for !pref.Contains("key") {
+		runtime.Gosched()
+	}
+	suite.Equal(pref.GetInt("key", 0), 5)
+	pref.diskLock.Unlock()
+	suite.True(<-ch)
+	_, err := os.Stat(basePath + PrefName)
+	suite.Nil(err)
+}
+
+func (suite *TestSuite) TestCommitAfterApply() {
+	pref.diskLock.Lock()
+	pref.Edit().Put("key", 5).Apply()
+	ch := make(chan bool)
+	go func() {
+		ch <- pref.Edit().Put("key", 15).Commit()
+	}()
+	pref.diskLock.Unlock()
+	suite.True(<-ch)
+
+	pref.loadWg.Add(1)
+	go pref.loadFromFile()
+	pref.loadWg.Wait()
+	suite.Equal(pref.values()["key"], 15)
+}
+
+func (suite *TestSuite) TestCompareAndPut() {
+	editor.CompareAndPut("key", nil, 1)
+	suite.True(editor.Commit())
+	suite.Equal(pref.GetInt("key", 0), 1)
+	suite.True(editor.CompareAndPut("key", 1, 2).Commit())
+	suite.Equal(pref.GetInt("key", 0), 2)
+	suite.Nil(editor.Err())
+}
+
+func (suite *TestSuite) TestCompareAndPutFailed() {
+	editor.Put("key1", 1).Put("key2", 2).Commit()
+	suite.False(pref.Edit().CompareAndPut("key1", 3, 4).CompareAndPut("key2", 2, 5).Put("key3", 6).Commit())
+	suite.Equal(pref.GetInt("key1", 0), 1)
+	suite.Equal(pref.GetInt("key2", 0), 2)
+	suite.False(pref.Contains("key3"))
+
+	e := pref.Edit().CompareAndPut("key2", nil, 5).CompareAndPut("key1", 3, 4)
+	e.Apply()
+	suite.Equal(e.Err(), &ConditionError{Keys: []string{"key1", "key2"}})
+	suite.Equal(pref.GetInt("key2", 0), 2)
+}
+
+func (suite *TestSuite) TestIncrement() {
+	suite.True(editor.Increment("count", 2).Commit())
+	suite.Equal(pref.GetInt("count", 0), 2)
+	suite.True(editor.Increment("count", -5).Commit())
+	suite.Equal(pref.GetInt("count", 0), -3)
+	suite.True(editor.Put("count64", int64(10)).Increment("count64", 1).Commit())
+	suite.Equal(pref.GetInt64("count64", 0), int64(11))
+	suite.True(editor.Put("float", 0.5).Commit())
+	suite.True(editor.Increment("float", 1).Commit())
+	suite.Equal(pref.GetFloat64("float", 0), 1.5)
+	suite.False(editor.Put("string", "value").Increment("string", 1).Commit())
+	suite.Equal(editor.Err(), &ConditionError{Keys: []string{"string"}})
+}
+
+func (suite *TestSuite) TestIncrementConcurrency() {
+	ch := make(chan bool, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			for i := 0; i < 100; i++ {
+				pref.Edit().Increment("count", 1).Apply()
+			}
+			ch <- true
+		}()
+	}
+	<-ch
+	<-ch
+	suite.Equal(pref.GetInt("count", 0), 200)
+}
+
+func (suite *TestSuite) TestRevision() {
+	suite.Equal(pref.Revision(), uint64(0))
+	editor.Put("key", 1).Commit()
+	suite.Equal(pref.Revision(), uint64(1))
+	editor.Put("key", 1).Commit()
+	suite.Equal(pref.Revision(), uint64(1))
+	editor.Remove("key").Apply()
+	suite.Equal(pref.Revision(), uint64(2))
+}
+
+func (suite *TestSuite) TestEditAt() {
+	editor.Put("key", 1).Commit()
+	revision := pref.Revision()
+	e := pref.EditAt(revision)
+	suite.True(e.Put("key", 2).Commit())
+	suite.True(e.Put("key", 3).Commit())
+	suite.Equal(pref.GetInt("key", 0), 3)
+
+	e = pref.EditAt(pref.Revision())
+	pref.Edit().Put("other", 1).Commit()
+	suite.False(e.Put("key", 4).Commit())
+	suite.Equal(e.Err(), ErrConflict)
+	suite.Equal(pref.GetInt("key", 0), 3)
 }
 
 func (suite *TestSuite) TestObserver() {
@@ -264,6 +376,28 @@ func (suite *TestSuite) TestObserverWithNoChanges() {
 	close(ch)
 }
 
+func (suite *TestSuite) TestObserverConcurrency() {
+	// A listener must not receive any key after it is unregistered, so that it can be closed right away.
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			pref.Edit().Put("key", i).Apply()
+		}
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		ch := make(chan string, 1)
+		pref.RegisterOnPreferenceChangeListener(ch)
+		pref.UnregisterOnPreferenceChangeListener(ch)
+		close(ch)
+	}
+}
+
 func (suite *TestSuite) TestApplyConcurrency() {
 	ch := make(chan bool, 2)
 	go func() {
@@ -360,8 +494,8 @@ func (suite *TestSuite) TestReadWritePrefFile() {
 	pref.loadWg.Add(1)
 	go pref.loadFromFile()
 	pref.loadWg.Wait()
-	suite.Len(pref.m, 1)
-	suite.Equal(pref.m["key"], "value")
+	suite.Len(pref.values(), 1)
+	suite.Equal(pref.values()["key"], "value")
 }
 
 func (suite *TestSuite) TestReadWriteBackupFile() {
@@ -370,8 +504,33 @@ func (suite *TestSuite) TestReadWriteBackupFile() {
 	editor.Put("key", "value").Commit()
 	_, err := os.Open(basePath + PrefName)
 	suite.Nil(err)
+	// Leave the file as a crash while saving Stranger does.
+	os.Rename(basePath+PrefName, basePath+PrefName+"_bak")
+	ioutil.WriteFile(basePath+PrefName, []byte("torn"), 0644)
+
+	pref.loadWg.Add(1)
+	go pref.loadFromFile()
+	pref.loadWg.Wait()
+	suite.Len(pref.values(), 1)
+	suite.Equal(pref.values()["key"], "value")
+	_, err = os.Open(basePath + PrefName + "_bak")
+	suite.True(os.IsNotExist(err))
+
 	editor.Put("key", Stranger{}).Commit()
-	_, err = os.Open(basePath + PrefName)
+	pref.loadWg.Add(1)
+	go pref.loadFromFile()
+	pref.loadWg.Wait()
+	suite.Equal(pref.values()["key"], Stranger{})
+}
+
+func (suite *TestSuite) TestWriteUnencodableValue() {
+	// gob cannot encode a struct without exported fields even if its type has been registered.
+	type Unencodable struct {
+		ch chan int
+	}
+	editor.Put("key", "value").Commit()
+	suite.False(editor.Put("key", Unencodable{}).Commit())
+	_, err := os.Open(basePath + PrefName)
 	suite.True(os.IsNotExist(err))
 	_, err = os.Open(basePath + PrefName + "_bak")
 	suite.Nil(err)
@@ -379,6 +538,95 @@ func (suite *TestSuite) TestReadWriteBackupFile() {
 	pref.loadWg.Add(1)
 	go pref.loadFromFile()
 	pref.loadWg.Wait()
-	suite.Len(pref.m, 1)
-	suite.Equal(pref.m["key"], "value")
+	suite.Len(pref.values(), 1)
+	suite.Equal(pref.values()["key"], "value")
+}
+
+func (suite *TestSuite) TestWatchFileChanged() {
+	editor.Put("key", "value").Commit()
+	suite.False(pref.storageChanged())
+	ch := make(chan string, 1)
+	pref.RegisterOnPreferenceChangeListener(ch)
+	stop := pref.Watch(10 * time.Millisecond)
+	defer stop()
+
+	newFileStorage(basePath+PrefName).Save(map[string]interface{}{"key": "changed"}, nil)
+	suite.Equal("key", <-ch)
+	suite.Equal("changed", pref.GetString("key", ""))
+}
+
+func (suite *TestSuite) TestReloadSkipsReservedKeys() {
+	editor.Put("key", "value").Commit()
+	ch := make(chan string, 2)
+	pref.RegisterOnPreferenceChangeListener(ch)
+
+	newFileStorage(basePath+PrefName).Save(map[string]interface{}{"key": "value", versionKey: 2}, nil)
+	suite.True(pref.Reload())
+	suite.Equal(2, pref.values()[versionKey])
+	newFileStorage(basePath+PrefName).Save(map[string]interface{}{"key": "changed", versionKey: 3}, nil)
+	suite.True(pref.Reload())
+	suite.Equal("key", <-ch)
+	suite.Len(ch, 0)
+}
+
+func newBenchmarkPreferences(b *testing.B) *PreferencesImpl {
+	p := newPreferencesImpl(PrefName)
+	for i := 0; i < 100; i++ {
+		p.Edit().Put(fmt.Sprintf("key%d", i), i).Commit()
+	}
+	b.Cleanup(func() {
+		os.Remove(basePath + PrefName)
+		os.Remove(basePath + PrefName + "_bak")
+	})
+	return p
+}
+
+func BenchmarkGet(b *testing.B) {
+	p := newBenchmarkPreferences(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			p.GetInt("key50", 0)
+		}
+	})
+}
+
+func BenchmarkGetWithConcurrentApply(b *testing.B) {
+	p := newBenchmarkPreferences(b)
+	benchmarkGetWithConcurrentWrites(b, func(i int) {
+		p.Edit().Put("key0", i).Apply()
+	}, p)
+}
+
+func BenchmarkGetWithConcurrentCommit(b *testing.B) {
+	p := newBenchmarkPreferences(b)
+	benchmarkGetWithConcurrentWrites(b, func(i int) {
+		p.Edit().Put("key0", i).Commit()
+	}, p)
+}
+
+// benchmarkGetWithConcurrentWrites measures the read throughput while another goroutine keeps writing.
+func benchmarkGetWithConcurrentWrites(b *testing.B, write func(int), p *PreferencesImpl) {
+	stop := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				close(done)
+				return
+			default:
+				write(i)
+			}
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			p.GetInt("key50", 0)
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	<-done
 }
@@ -0,0 +1,237 @@
+package pref
+
+import (
+	"errors"
+	"reflect"
+	"time"
+)
+
+// ErrReadOnly is the error of a commit by an editor of a read-only or frozen Preferences.
+var ErrReadOnly = errors.New("pref: preferences is read-only")
+
+// maxFreezeAttempts is the number of times Freeze reads a Preferences other than PreferencesImpl until its
+// key-values and revision are read without a change in between.
+const maxFreezeAttempts = 10
+
+// readOnly is a Preferences which reads from another one, and rejects all the changes.
+type readOnly struct {
+	Reader
+	p Preferences
+}
+
+// ReadOnly returns a view of p which can read its current key-values but not modify them, the commits of
+// its editors and its transactions fail with ErrReadOnly, and SetDefaults is ignored. The changes made
+// through p itself are still visible, and notified to the listeners.
+func ReadOnly(p Preferences) Preferences {
+	if _, ok := p.(*readOnly); ok {
+		return p
+	}
+	return &readOnly{Reader: p, p: p}
+}
+
+func (r *readOnly) RegisterOnPreferenceChangeListener(observer OnPreferenceChangeListener) {
+	r.p.RegisterOnPreferenceChangeListener(observer)
+}
+
+func (r *readOnly) UnregisterOnPreferenceChangeListener(observer OnPreferenceChangeListener) {
+	r.p.UnregisterOnPreferenceChangeListener(observer)
+}
+
+func (r *readOnly) Revision() uint64 {
+	return r.p.Revision()
+}
+
+func (r *readOnly) GetAll() map[string]interface{} {
+	return r.p.GetAll()
+}
+
+// SetDefaults is ignored, since the defaults would change the values read through p.
+func (r *readOnly) SetDefaults(map[string]interface{}) {}
+
+func (r *readOnly) GetOrDefault(key string) interface{} {
+	return r.p.GetOrDefault(key)
+}
+
+func (r *readOnly) IsDefault(key string) bool {
+	return r.p.IsDefault(key)
+}
+
+// Edit returns an editor whose commits fail with ErrReadOnly.
+func (r *readOnly) Edit() Editor {
+	return &readOnlyEditor{}
+}
+
+// EditAt returns an editor whose commits fail with ErrReadOnly.
+func (r *readOnly) EditAt(uint64) Editor {
+	return &readOnlyEditor{}
+}
+
+// Update returns ErrReadOnly without running fn.
+func (r *readOnly) Update(func(Tx) error) error {
+	return ErrReadOnly
+}
+
+// readOnlyEditor is the Editor of a read-only or frozen Preferences, it discards all the changes.
+type readOnlyEditor struct {
+	err error
+}
+
+func (e *readOnlyEditor) Apply() {
+	e.err = ErrReadOnly
+}
+
+func (e *readOnlyEditor) Commit() bool {
+	e.err = ErrReadOnly
+	return false
+}
+
+func (e *readOnlyEditor) Clear() Editor {
+	return e
+}
+
+func (e *readOnlyEditor) Remove(string) Editor {
+	return e
+}
+
+func (e *readOnlyEditor) Put(string, interface{}) Editor {
+	return e
+}
+
+func (e *readOnlyEditor) CompareAndPut(string, interface{}, interface{}) Editor {
+	return e
+}
+
+func (e *readOnlyEditor) Increment(string, int64) Editor {
+	return e
+}
+
+func (e *readOnlyEditor) PutWithTTL(string, interface{}, time.Duration) Editor {
+	return e
+}
+
+// Err returns ErrReadOnly after Apply or Commit.
+func (e *readOnlyEditor) Err() error {
+	return e.err
+}
+
+// frozen is an immutable snapshot of the key-values of a Preferences.
+type frozen struct {
+	TypedGetters
+	m        map[string]interface{}
+	revision uint64
+	defaults map[string]interface{}
+}
+
+// Freeze returns an immutable snapshot of the current key-values of p with its revision, such as for
+// handling a request with the same values from beginning to end. The snapshot never changes, so its
+// listeners are never notified, and it is read-only as ReadOnly. The expired keys are excluded when the
+// snapshot is taken, and the others never expire in it. The registered defaults are kept in the snapshot
+// if p is a *PreferencesImpl, otherwise the snapshot has no defaults.
+func Freeze(p Preferences) Preferences {
+	f := &frozen{defaults: make(map[string]interface{})}
+	f.TypedGetters = TypedGetters{f.GetObject}
+	switch impl := p.(type) {
+	case *frozen:
+		return impl
+	case *readOnly:
+		return Freeze(impl.p)
+	case *PreferencesImpl:
+		impl.loadWg.Wait()
+		s := impl.current()
+		f.m, f.revision = impl.visible(s.m), s.revision
+		for k, v := range impl.defaults.Load().(map[string]interface{}) {
+			f.defaults[k] = v
+		}
+		if schema := impl.schema.Load().(*Schema); schema != nil {
+			schema.RLock()
+			for k, spec := range schema.specs {
+				if _, exist := f.defaults[k]; !exist && spec.Default != nil {
+					f.defaults[k] = spec.Default
+				}
+			}
+			schema.RUnlock()
+		}
+	default:
+		// Read again if p is changed while it is read, so the key-values match the revision.
+		for i := 0; i < maxFreezeAttempts; i++ {
+			f.revision = p.Revision()
+			f.m = p.GetAll()
+			if p.Revision() == f.revision {
+				break
+			}
+		}
+	}
+	return f
+}
+
+// Contains returns whether a key exists in the snapshot.
+func (f *frozen) Contains(key string) bool {
+	_, exist := f.m[key]
+	return exist
+}
+
+// GetObject returns the object value in the snapshot, and return default value if the key does not exist.
+// The default value in the snapshot takes precedence over the given one.
+func (f *frozen) GetObject(key string, defaultValue interface{}) interface{} {
+	if v, exist := f.m[key]; exist {
+		if v, ok := resolve(v); ok {
+			return v
+		}
+		return defaultValue
+	}
+	if v, exist := f.defaults[key]; exist {
+		return v
+	}
+	return defaultValue
+}
+
+// GetAll returns a copy of all the key-values in the snapshot.
+func (f *frozen) GetAll() map[string]interface{} {
+	all := make(map[string]interface{}, len(f.m))
+	for k, v := range f.m {
+		all[k] = v
+	}
+	return all
+}
+
+// Revision returns the revision of p when the snapshot was taken.
+func (f *frozen) Revision() uint64 {
+	return f.revision
+}
+
+// GetOrDefault returns the value of a key in the snapshot, or its default value, or nil.
+func (f *frozen) GetOrDefault(key string) interface{} {
+	return f.GetObject(key, nil)
+}
+
+// IsDefault returns whether the value of a key in the snapshot is its default value.
+func (f *frozen) IsDefault(key string) bool {
+	v, exist := f.m[key]
+	if !exist {
+		return true
+	}
+	registered, exist := f.defaults[key]
+	return exist && reflect.DeepEqual(v, registered)
+}
+
+// SetDefaults is ignored, since the snapshot is immutable.
+func (f *frozen) SetDefaults(map[string]interface{}) {}
+
+func (f *frozen) RegisterOnPreferenceChangeListener(OnPreferenceChangeListener) {}
+
+func (f *frozen) UnregisterOnPreferenceChangeListener(OnPreferenceChangeListener) {}
+
+// Edit returns an editor whose commits fail with ErrReadOnly.
+func (f *frozen) Edit() Editor {
+	return &readOnlyEditor{}
+}
+
+// EditAt returns an editor whose commits fail with ErrReadOnly.
+func (f *frozen) EditAt(uint64) Editor {
+	return &readOnlyEditor{}
+}
+
+// Update returns ErrReadOnly without running fn.
+func (f *frozen) Update(func(Tx) error) error {
+	return ErrReadOnly
+}
@@ -0,0 +1,85 @@
+package pref
+
+import (
+	"github.com/stretchr/testify/suite"
+	"os"
+	"testing"
+	"time"
+)
+
+type ReadOnlyTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ReadOnlyTestSuite) SetupTest() {
+	basePath = "./"
+	pref = newPreferencesImpl(PrefName)
+}
+
+func (suite *ReadOnlyTestSuite) TearDownTest() {
+	os.Remove(basePath + PrefName)
+	os.Remove(basePath + PrefName + "_bak")
+}
+
+func TestReadOnlyTestSuite(t *testing.T) {
+	suite.Run(t, new(ReadOnlyTestSuite))
+}
+
+func (suite *ReadOnlyTestSuite) TestReadOnly() {
+	suite.True(pref.Edit().Put("name", "alice").Commit())
+	ro := ReadOnly(pref)
+	suite.Equal("alice", ro.GetString("name", ""))
+
+	editor := ro.Edit().Clear().Put("name", "bob")
+	suite.NoError(editor.Err())
+	suite.False(editor.Commit())
+	suite.Equal(ErrReadOnly, editor.Err())
+	suite.Equal(ErrReadOnly, ro.Update(func(tx Tx) error {
+		suite.Fail("fn must not run")
+		return nil
+	}))
+	ro.SetDefaults(map[string]interface{}{"lang": "en"})
+	suite.Nil(pref.GetOrDefault("lang"))
+	suite.Equal("alice", pref.GetString("name", ""))
+
+	// The changes made through the Preferences are visible.
+	ch := make(chan string, 1)
+	ro.RegisterOnPreferenceChangeListener(ch)
+	suite.True(pref.Edit().Put("name", "carol").Commit())
+	suite.Equal("name", <-ch)
+	suite.Equal("carol", ro.GetString("name", ""))
+	suite.Equal(pref.Revision(), ro.Revision())
+	suite.Equal(ro, ReadOnly(ro))
+}
+
+func (suite *ReadOnlyTestSuite) TestFreeze() {
+	pref.SetDefaults(map[string]interface{}{"lang": "en"})
+	suite.True(pref.Edit().Put("name", "alice").Put("count", 1).Commit())
+	f := Freeze(pref)
+	revision := pref.Revision()
+
+	suite.True(pref.Edit().Put("name", "bob").Remove("count").Commit())
+	pref.SetDefaults(map[string]interface{}{"lang": "fr"})
+	suite.Equal("alice", f.GetString("name", ""))
+	suite.Equal(1, f.GetInt("count", 0))
+	suite.Equal("en", f.GetString("lang", ""))
+	suite.True(f.IsDefault("lang"))
+	suite.Equal(revision, f.Revision())
+	suite.Equal(map[string]interface{}{"name": "alice", "count": 1}, f.GetAll())
+
+	editor := f.Edit().Put("name", "carol")
+	suite.False(editor.Commit())
+	suite.Equal(ErrReadOnly, editor.Err())
+	suite.Equal("alice", f.GetString("name", ""))
+	suite.Equal(f, Freeze(f))
+}
+
+func (suite *ReadOnlyTestSuite) TestFreezeExcludesExpired() {
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	WithClock(func() time.Time { return clock })(pref)
+	suite.True(pref.Edit().PutWithTTL("token", "abc", time.Minute).Commit())
+	f := Freeze(ReadOnly(pref))
+	clock = clock.Add(time.Hour)
+	suite.Equal("abc", f.GetString("token", ""))
+	suite.False(Freeze(pref).Contains("token"))
+}
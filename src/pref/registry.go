@@ -0,0 +1,72 @@
+package pref
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// registeredTypes keeps the types which have been registered to gob by registerType.
+var registeredTypes = new(sync.Map)
+
+// RawValue is a value which could not be decoded when the Preferences was loaded, usually because its type
+// had not been registered to gob. It keeps the encoded value, so the value is preserved when the
+// Preferences is saved again, and the getters decode it again once its type has been registered.
+type RawValue struct {
+	data []byte
+}
+
+// Decode decodes the value again, it succeeds if the type of value has been registered since it was
+// loaded.
+func (r RawValue) Decode() (interface{}, error) {
+	return gobDecodeValue(r.data)
+}
+
+func (r RawValue) String() string {
+	return fmt.Sprintf("RawValue(%d bytes)", len(r.data))
+}
+
+// MarshalJSON writes the description of the value as a JSON string.
+func (r RawValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// WithTypes registers the types of the values to gob before the Preferences is loaded, so the values of
+// these types are decoded instead of being kept as RawValue.
+func WithTypes(values ...interface{}) Option {
+	return func(p *PreferencesImpl) {
+		for _, v := range values {
+			registerType(v)
+		}
+	}
+}
+
+// registerType registers the type of a value to gob, so it can be encoded as an interface value. A type
+// whose name conflicts with another registered type is logged and skipped.
+func registerType(v interface{}) {
+	if v == nil {
+		return
+	}
+	t := reflect.TypeOf(v)
+	if _, loaded := registeredTypes.LoadOrStore(t, true); loaded {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Cannot register type %v: %v", t, r)
+		}
+	}()
+	gob.Register(v)
+}
+
+// resolve returns the decoded value of a RawValue, and whether the value is available.
+func resolve(v interface{}) (interface{}, bool) {
+	if raw, ok := v.(RawValue); ok {
+		decoded, err := raw.Decode()
+		return decoded, err == nil
+	}
+	return v, true
+}
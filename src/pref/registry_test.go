@@ -0,0 +1,146 @@
+package pref
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+// knownPoint is registered to gob, and unknownValue encodes it under another name of the same length which
+// has not been registered.
+type knownPoint struct {
+	X, Y int
+}
+
+type unknownPoint struct {
+	X, Y int
+}
+
+type autoPoint struct {
+	X, Y int
+}
+
+type optionPoint struct {
+	X, Y int
+}
+
+const knownPointName = "pref.knownPoint___"
+
+// registerPointOnce registers unknownPoint only once, since the tests may be run several times in a process.
+var registerPointOnce = new(sync.Once)
+
+func init() {
+	gob.RegisterName(knownPointName, knownPoint{})
+}
+
+// unknownValue returns an encoded value whose type name has not been registered, the name must have the
+// same length as knownPointName.
+func unknownValue(name string) RawValue {
+	data, _ := encodeValue(knownPoint{X: 1, Y: 2})
+	return RawValue{data: bytes.Replace(data, []byte(knownPointName), []byte(name), 1)}
+}
+
+type RegistryTestSuite struct {
+	suite.Suite
+}
+
+func (suite *RegistryTestSuite) SetupTest() {
+	basePath = "./"
+	pref = newPreferencesImpl(PrefName)
+}
+
+func (suite *RegistryTestSuite) TearDownTest() {
+	os.Remove(basePath + PrefName)
+	os.Remove(basePath + PrefName + "_bak")
+	os.Remove(basePath + PrefName + ".log")
+	os.Remove(basePath + PrefName + legacySuffix)
+}
+
+func TestRegistryTestSuite(t *testing.T) {
+	suite.Run(t, new(RegistryTestSuite))
+}
+
+func (suite *RegistryTestSuite) TestUnknownTypeKeepsOtherKeys() {
+	raw := unknownValue("pref.unknownPointA")
+	suite.Nil(pref.storage.Save(map[string]interface{}{"key": 1, "point": raw}, nil))
+
+	pref = load(PrefName)
+	suite.Equal(1, pref.GetInt("key", 0))
+	suite.True(pref.Contains("point"))
+	suite.Equal("default", pref.GetObject("point", "default"))
+	suite.IsType(RawValue{}, pref.values()["point"])
+}
+
+func (suite *RegistryTestSuite) TestUnknownTypePreservedOnSave() {
+	raw := unknownValue("pref.unknownPointB")
+	suite.Nil(pref.storage.Save(map[string]interface{}{"point": raw}, nil))
+
+	pref = load(PrefName)
+	suite.True(pref.Edit().Put("key", 2).Commit())
+	pref = load(PrefName)
+	suite.Equal(2, pref.GetInt("key", 0))
+	suite.Equal(raw, pref.values()["point"])
+}
+
+func (suite *RegistryTestSuite) TestUnknownTypeInLog() {
+	WithLogStorage(DefaultCompactThreshold)(pref)
+	raw := unknownValue("pref.unknownPointC")
+	suite.Nil(pref.storage.Save(nil, []Record{{Op: OpPut, Key: "point", Value: raw}, {Op: OpPut, Key: "key", Value: 1}}))
+
+	pref = newPreferencesImpl(PrefName)
+	WithLogStorage(DefaultCompactThreshold)(pref)
+	pref.loadWg.Add(1)
+	pref.loadFromFile()
+	suite.Equal(1, pref.GetInt("key", 0))
+	suite.Equal(raw, pref.values()["point"])
+}
+
+func (suite *RegistryTestSuite) TestDecodeAfterRegister() {
+	raw := unknownValue("pref.unknownPointD")
+	registerPointOnce.Do(func() {
+		_, err := raw.Decode()
+		suite.Error(err)
+		gob.RegisterName("pref.unknownPointD", unknownPoint{})
+	})
+	v, err := raw.Decode()
+	suite.Nil(err)
+	suite.Equal(unknownPoint{X: 1, Y: 2}, v)
+}
+
+func (suite *RegistryTestSuite) TestPutRegistersType() {
+	suite.True(pref.Edit().Put("point", autoPoint{X: 3, Y: 4}).Commit())
+	suite.Nil(pref.Edit().Err())
+
+	pref = load(PrefName)
+	suite.Equal(autoPoint{X: 3, Y: 4}, pref.GetObject("point", nil))
+}
+
+func (suite *RegistryTestSuite) TestWithTypes() {
+	WithTypes(optionPoint{})(pref)
+	data, err := encodeValue(optionPoint{X: 5, Y: 6})
+	suite.Nil(err)
+	suite.Equal(optionPoint{X: 5, Y: 6}, decodeValue(data))
+}
+
+func (suite *RegistryTestSuite) TestUnknownTypeInLegacyFile() {
+	// The earlier versions encoded the map as a whole.
+	m := map[string]interface{}{"key": 1, "point": knownPoint{X: 3, Y: 4}}
+	var buf bytes.Buffer
+	suite.Nil(gob.NewEncoder(&buf).Encode(&m))
+	data := bytes.Replace(buf.Bytes(), []byte(knownPointName), []byte("pref.unknownPointA"), 1)
+	suite.Nil(ioutil.WriteFile(basePath+PrefName, data, 0644))
+
+	_, err := newFileStorage(basePath + PrefName).Load()
+	suite.Error(err)
+	pref = load(PrefName)
+	suite.Empty(pref.values())
+	// The file is kept untouched after it is replaced by a save.
+	suite.True(pref.Edit().Put("key", 2).Commit())
+	legacy, err := ioutil.ReadFile(basePath + PrefName + legacySuffix)
+	suite.Nil(err)
+	suite.Equal(data, legacy)
+}
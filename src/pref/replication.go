@@ -0,0 +1,301 @@
+package pref
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+func init() {
+	gob.Register(clockEntry{})
+	registerDirCodec("clock", clockEntry{}, dirCodec{format: formatClock, parse: parseClock})
+}
+
+// clockPrefix is the prefix of the reserved keys which keep the clock entries of the keys.
+const clockPrefix = reservedPrefix + "clock/"
+
+// clearKey is the reserved key of the clock entry of the last clear.
+const clearKey = reservedPrefix + "clear"
+
+// ErrNotReplicated is returned by ApplyDelta if the Preferences has not been created with WithReplica.
+var ErrNotReplicated = errors.New("pref: preferences is not replicated")
+
+// Timestamp is a hybrid logical clock, it follows the wall time of the replicas but never goes backwards,
+// and the logical counter orders the changes made within the same wall time. The replica breaks the ties
+// between the replicas, so timestamps are totally ordered.
+type Timestamp struct {
+	Wall    int64
+	Logical uint32
+	Replica string
+}
+
+// Before returns whether t is ordered before u.
+func (t Timestamp) Before(u Timestamp) bool {
+	if t.Wall != u.Wall {
+		return t.Wall < u.Wall
+	}
+	if t.Logical != u.Logical {
+		return t.Logical < u.Logical
+	}
+	return t.Replica < u.Replica
+}
+
+// IsZero returns whether t is the zero timestamp, which is ordered before any other one.
+func (t Timestamp) IsZero() bool {
+	return t == Timestamp{}
+}
+
+// Delta is the changes of a replicated Preferences exported by ExportDelta.
+type Delta struct {
+	// Entries are the latest changes of the keys sorted by key, a removed key is a tombstone entry.
+	Entries []DeltaEntry
+	// Clear is the timestamp of the last clear, or zero if it is not in the delta.
+	Clear Timestamp
+	// Until is the local clock of the exporter, pass it to the next ExportDelta to get the following
+	// changes only.
+	Until Timestamp
+}
+
+// DeltaEntry is the latest change of a key.
+type DeltaEntry struct {
+	Key     string
+	Value   interface{}
+	Removed bool
+	Time    Timestamp
+}
+
+// clockEntry is stored under the reserved key of every changed key, so a removed key is kept as a
+// tombstone. Time is when the change was made by any replica, and Local is when it was written to this
+// replica, which decides whether it is exported.
+type clockEntry struct {
+	Time    Timestamp
+	Local   Timestamp
+	Removed bool
+}
+
+// formatClock formats a clockEntry as JSON in the dir storage.
+func formatClock(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+func parseClock(text string) (interface{}, error) {
+	var entry clockEntry
+	if err := json.Unmarshal([]byte(text), &entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// replica keeps the hybrid logical clock of a replicated Preferences.
+type replica struct {
+	id   string
+	last Timestamp
+	// loaded is set once last has been advanced past the timestamps stored with the key-values.
+	loaded bool
+}
+
+// WithReplica enables the replication of the Preferences as the replica of the given id, which must be
+// unique among the replicas of the same Preferences. The time of the last change of every key is tracked,
+// the removed keys are kept as tombstones, and the replicas converge to the same key-values by exchanging
+// the deltas of ExportDelta and ApplyDelta in any order, the latest change of a key wins. The clock entries
+// are stored with the key-values by any storage. Only the keys changed after the replication is enabled are
+// exported.
+func WithReplica(id string) Option {
+	return func(p *PreferencesImpl) {
+		p.replica = &replica{id: id}
+	}
+}
+
+// tickLocked advances the clock for a local change or for applying a delta whose latest timestamp is
+// remote, and returns the new time.
+func (p *PreferencesImpl) tickLocked(m map[string]interface{}, remote Timestamp) Timestamp {
+	r := p.replica
+	r.load(m)
+	r.observe(remote)
+	wall := p.now().UnixNano()
+	if wall > r.last.Wall {
+		r.last = Timestamp{Wall: wall, Replica: r.id}
+	} else {
+		r.last = Timestamp{Wall: r.last.Wall, Logical: r.last.Logical + 1, Replica: r.id}
+	}
+	return r.last
+}
+
+// load advances the clock past the timestamps stored with the key-values when it is used for the first time.
+func (r *replica) load(m map[string]interface{}) {
+	if r.loaded {
+		return
+	}
+	for k, v := range m {
+		if entry, ok := v.(clockEntry); ok && isReserved(k) {
+			r.observe(entry.Time)
+			r.observe(entry.Local)
+		}
+	}
+	r.loaded = true
+}
+
+// observe moves the clock forward to a timestamp seen in the key-values or in a delta.
+func (r *replica) observe(t Timestamp) {
+	if t.Wall > r.last.Wall || t.Wall == r.last.Wall && t.Logical > r.last.Logical {
+		r.last = Timestamp{Wall: t.Wall, Logical: t.Logical, Replica: r.id}
+	}
+}
+
+// clockOf returns the clock entry stored in m for the reserved key k of a key.
+func clockOf(m map[string]interface{}, k string) (clockEntry, bool) {
+	if !strings.HasPrefix(k, clockPrefix) {
+		return clockEntry{}, false
+	}
+	entry, ok := m[k].(clockEntry)
+	return entry, ok
+}
+
+// lastClear returns the clock entry of the last clear stored in m.
+func lastClear(m map[string]interface{}) clockEntry {
+	entry, _ := m[clearKey].(clockEntry)
+	return entry
+}
+
+// stampLocked records the clock entries of the keys changed by an editor in m, and returns the records
+// with the ones of the clock entries appended. A clear also records its time, so the changes made before
+// it by other replicas are dropped when they arrive later.
+func (p *PreferencesImpl) stampLocked(m map[string]interface{}, keys []string, cleared bool, records []Record) []Record {
+	if p.replica == nil || len(keys) == 0 && !cleared {
+		return records
+	}
+	now := p.tickLocked(m, Timestamp{})
+	if cleared {
+		entry := clockEntry{Time: now, Local: now}
+		m[clearKey] = entry
+		records = append(records, Record{Op: OpPut, Key: clearKey, Value: entry})
+	}
+	for _, k := range keys {
+		if isReserved(k) {
+			continue
+		}
+		_, exist := m[k]
+		entry := clockEntry{Time: now, Local: now, Removed: !exist}
+		m[clockPrefix+k] = entry
+		records = append(records, Record{Op: OpPut, Key: clockPrefix + k, Value: entry})
+	}
+	return records
+}
+
+// ExportDelta returns the changes of the replicated Preferences which have been written to this replica
+// after since, including the changes applied from other replicas, so the deltas can be relayed through
+// any replica. A zero since exports all the changes. It returns an empty delta if the Preferences is not
+// replicated.
+func (p *PreferencesImpl) ExportDelta(since Timestamp) Delta {
+	p.loadWg.Wait()
+	p.Lock()
+	defer p.Unlock()
+	delta := Delta{Entries: make([]DeltaEntry, 0)}
+	if p.replica == nil {
+		return delta
+	}
+	m := p.values()
+	for k := range m {
+		entry, ok := clockOf(m, k)
+		if !ok || !since.Before(entry.Local) {
+			continue
+		}
+		key := strings.TrimPrefix(k, clockPrefix)
+		de := DeltaEntry{Key: key, Removed: entry.Removed, Time: entry.Time}
+		if !entry.Removed {
+			de.Value = m[key]
+		}
+		delta.Entries = append(delta.Entries, de)
+	}
+	sort.Slice(delta.Entries, func(i, j int) bool {
+		return delta.Entries[i].Key < delta.Entries[j].Key
+	})
+	if clear := lastClear(m); since.Before(clear.Local) {
+		delta.Clear = clear.Time
+	}
+	p.replica.load(m)
+	delta.Until = p.replica.last
+	return delta
+}
+
+// ApplyDelta merges the changes exported by another replica, a change is applied if it is later than the
+// last change of its key, and the keys changed before a later clear are removed. Applying the same delta
+// again, or the deltas in another order, leads to the same key-values. The listeners are notified of the
+// changed keys, and ApplyDelta returns after the changes have been written to storage.
+func (p *PreferencesImpl) ApplyDelta(delta Delta) error {
+	if p.replica == nil {
+		return ErrNotReplicated
+	}
+	p.loadWg.Wait()
+	p.Lock()
+	m := p.copyOfMapLocked()
+	latest := delta.Clear
+	for _, de := range delta.Entries {
+		if latest.Before(de.Time) {
+			latest = de.Time
+		}
+	}
+	now := p.tickLocked(m, latest)
+	changed := make(map[string]bool)
+	records := make([]Record, 0)
+	put := func(k string, v interface{}) {
+		m[k] = v
+		records = append(records, Record{Op: OpPut, Key: k, Value: v})
+	}
+	// A later clear removes the keys whose last change is before it, as the clear of an editor would.
+	if lastClear(m).Time.Before(delta.Clear) {
+		put(clearKey, clockEntry{Time: delta.Clear, Local: now})
+		for k := range m {
+			if isReserved(k) {
+				continue
+			}
+			if entry, ok := clockOf(m, clockPrefix+k); ok && !entry.Time.Before(delta.Clear) {
+				continue
+			}
+			delete(m, k)
+			records = append(records, Record{Op: OpRemove, Key: k})
+			put(clockPrefix+k, clockEntry{Time: delta.Clear, Local: now, Removed: true})
+			changed[k] = true
+		}
+	}
+	clear := lastClear(m).Time
+	for _, de := range delta.Entries {
+		if isReserved(de.Key) || de.Time.Before(clear) {
+			continue
+		}
+		if entry, ok := clockOf(m, clockPrefix+de.Key); ok && !entry.Time.Before(de.Time) {
+			continue
+		}
+		if _, exist := m[de.Key]; exist || !de.Removed {
+			changed[de.Key] = true
+		}
+		if de.Removed {
+			delete(m, de.Key)
+			records = append(records, Record{Op: OpRemove, Key: de.Key})
+		} else {
+			registerType(de.Value)
+			put(de.Key, de.Value)
+		}
+		put(clockPrefix+de.Key, clockEntry{Time: de.Time, Local: now, Removed: de.Removed})
+	}
+	if len(records) == 0 {
+		p.Unlock()
+		return nil
+	}
+	keys := make([]string, 0, len(changed))
+	for k := range changed {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	p.publishLocked(m)
+	result := make(chan error, 1)
+	executor.Execute(func() {
+		result <- p.commitToDisk(m, records)
+	})
+	p.notifyObservers(keys)
+	p.Unlock()
+	return <-result
+}
@@ -0,0 +1,185 @@
+package pref
+
+import (
+	"github.com/stretchr/testify/suite"
+	"os"
+	"testing"
+	"time"
+)
+
+type ReplicationTestSuite struct {
+	suite.Suite
+	clock time.Time
+	a, b  *PreferencesImpl
+}
+
+func (suite *ReplicationTestSuite) SetupTest() {
+	basePath = "./"
+	suite.clock = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	suite.a = suite.replica("replicaA")
+	suite.b = suite.replica("replicaB")
+}
+
+func (suite *ReplicationTestSuite) TearDownTest() {
+	for _, name := range []string{"replicaA", "replicaB", "replicaC"} {
+		os.Remove(basePath + name)
+		os.Remove(basePath + name + "_bak")
+	}
+}
+
+func TestReplicationTestSuite(t *testing.T) {
+	suite.Run(t, new(ReplicationTestSuite))
+}
+
+func (suite *ReplicationTestSuite) now() time.Time {
+	return suite.clock
+}
+
+func (suite *ReplicationTestSuite) replica(name string) *PreferencesImpl {
+	p := newPreferencesImpl(name)
+	WithReplica(name)(p)
+	WithClock(suite.now)(p)
+	return p
+}
+
+// sync exchanges all the changes between the replicas.
+func (suite *ReplicationTestSuite) sync(replicas ...*PreferencesImpl) {
+	for _, from := range replicas {
+		for _, to := range replicas {
+			if from != to {
+				suite.NoError(to.ApplyDelta(from.ExportDelta(Timestamp{})))
+			}
+		}
+	}
+}
+
+func (suite *ReplicationTestSuite) TestPutAndRemove() {
+	suite.True(suite.a.Edit().Put("name", "alice").Put("age", 30).Commit())
+	suite.sync(suite.a, suite.b)
+	suite.Equal(map[string]interface{}{"name": "alice", "age": 30}, suite.b.GetAll())
+
+	suite.clock = suite.clock.Add(time.Second)
+	suite.True(suite.b.Edit().Remove("age").Commit())
+	suite.sync(suite.a, suite.b)
+	suite.Equal(map[string]interface{}{"name": "alice"}, suite.a.GetAll())
+	suite.Equal(suite.a.GetAll(), suite.b.GetAll())
+}
+
+func (suite *ReplicationTestSuite) TestLastWriterWins() {
+	suite.True(suite.a.Edit().Put("theme", "dark").Commit())
+	suite.clock = suite.clock.Add(time.Second)
+	suite.True(suite.b.Edit().Put("theme", "light").Commit())
+	suite.sync(suite.a, suite.b)
+	suite.Equal("light", suite.a.GetString("theme", ""))
+	suite.Equal("light", suite.b.GetString("theme", ""))
+}
+
+func (suite *ReplicationTestSuite) TestSameTimeConverges() {
+	suite.True(suite.a.Edit().Put("theme", "dark").Commit())
+	suite.True(suite.b.Edit().Put("theme", "light").Commit())
+	// The tie is broken by the replica ids in both orders.
+	suite.NoError(suite.a.ApplyDelta(suite.b.ExportDelta(Timestamp{})))
+	suite.NoError(suite.b.ApplyDelta(suite.a.ExportDelta(Timestamp{})))
+	suite.Equal("light", suite.a.GetString("theme", ""))
+	suite.Equal("light", suite.b.GetString("theme", ""))
+}
+
+func (suite *ReplicationTestSuite) TestClockSkew() {
+	suite.True(suite.a.Edit().Put("theme", "dark").Commit())
+	suite.NoError(suite.b.ApplyDelta(suite.a.ExportDelta(Timestamp{})))
+	// The clock of b is behind, but its change after seeing the one of a still wins.
+	suite.clock = suite.clock.Add(-time.Hour)
+	suite.True(suite.b.Edit().Put("theme", "light").Commit())
+	suite.sync(suite.a, suite.b)
+	suite.Equal("light", suite.a.GetString("theme", ""))
+}
+
+func (suite *ReplicationTestSuite) TestClear() {
+	suite.True(suite.a.Edit().Put("x", 1).Put("y", 2).Commit())
+	suite.sync(suite.a, suite.b)
+	// A change made by b before the clear is dropped, a later one is kept.
+	suite.clock = suite.clock.Add(time.Second)
+	suite.True(suite.b.Edit().Put("w", 4).Commit())
+	suite.clock = suite.clock.Add(time.Second)
+	suite.True(suite.a.Edit().Clear().Put("z", 3).Commit())
+	suite.clock = suite.clock.Add(time.Second)
+	suite.True(suite.b.Edit().Put("y", 5).Commit())
+
+	suite.sync(suite.a, suite.b)
+	want := map[string]interface{}{"y": 5, "z": 3}
+	suite.Equal(want, suite.a.GetAll())
+	suite.Equal(want, suite.b.GetAll())
+}
+
+func (suite *ReplicationTestSuite) TestIncremental() {
+	suite.True(suite.a.Edit().Put("x", 1).Commit())
+	delta := suite.a.ExportDelta(Timestamp{})
+	suite.Len(delta.Entries, 1)
+	suite.NoError(suite.b.ApplyDelta(delta))
+
+	suite.clock = suite.clock.Add(time.Second)
+	suite.True(suite.a.Edit().Put("y", 2).Commit())
+	next := suite.a.ExportDelta(delta.Until)
+	suite.Equal([]DeltaEntry{{Key: "y", Value: 2, Time: next.Entries[0].Time}}, next.Entries)
+	suite.Empty(suite.a.ExportDelta(next.Until).Entries)
+
+	// The changes applied from a are relayed by b to c.
+	suite.NoError(suite.b.ApplyDelta(next))
+	c := suite.replica("replicaC")
+	suite.NoError(c.ApplyDelta(suite.b.ExportDelta(Timestamp{})))
+	suite.Equal(map[string]interface{}{"x": 1, "y": 2}, c.GetAll())
+}
+
+func (suite *ReplicationTestSuite) TestApplyNotifiesAndIsIdempotent() {
+	suite.True(suite.a.Edit().Put("x", 1).Commit())
+	ch := make(chan string, 2)
+	suite.b.RegisterOnPreferenceChangeListener(ch)
+	delta := suite.a.ExportDelta(Timestamp{})
+	suite.NoError(suite.b.ApplyDelta(delta))
+	suite.Equal("x", <-ch)
+	revision := suite.b.Revision()
+	suite.NoError(suite.b.ApplyDelta(delta))
+	suite.Equal(revision, suite.b.Revision())
+	suite.Len(ch, 0)
+}
+
+func (suite *ReplicationTestSuite) TestPersistClock() {
+	suite.True(suite.a.Edit().Put("x", 1).Remove("x").Put("y", 2).Commit())
+	suite.True(suite.a.Edit().Remove("y").Commit())
+
+	a := load("replicaA")
+	WithReplica("replicaA")(a)
+	WithClock(suite.now)(a)
+	delta := a.ExportDelta(Timestamp{})
+	suite.Len(delta.Entries, 1)
+	suite.True(delta.Entries[0].Removed)
+	// The clock continues after the stored timestamps.
+	suite.True(a.Edit().Put("y", 3).Commit())
+	suite.NoError(suite.b.ApplyDelta(suite.a.ExportDelta(Timestamp{})))
+	suite.NoError(suite.b.ApplyDelta(a.ExportDelta(Timestamp{})))
+	suite.Equal(3, suite.b.GetInt("y", 0))
+}
+
+func (suite *ReplicationTestSuite) TestStorages() {
+	for name, option := range storageOptions {
+		a := newPreferencesImpl("replicaC")
+		option(a)
+		WithReplica("replicaC")(a)
+		WithClock(suite.now)(a)
+		suite.True(a.Edit().Put("x", 1).Put("y", "value").Commit(), name)
+		suite.True(a.Edit().Remove("x").Commit(), name)
+
+		a = load("replicaC", option, WithReplica("replicaC"), WithClock(suite.now))
+		delta := a.ExportDelta(Timestamp{})
+		suite.Len(delta.Entries, 2, name)
+		suite.NoError(suite.b.ApplyDelta(delta), name)
+		suite.Equal(map[string]interface{}{"y": "value"}, suite.b.GetAll(), name)
+		removeStorages("replicaC")
+	}
+}
+
+func (suite *ReplicationTestSuite) TestNotReplicated() {
+	p := newPreferencesImpl("replicaC")
+	suite.Equal(ErrNotReplicated, p.ApplyDelta(Delta{}))
+	suite.Empty(p.ExportDelta(Timestamp{}).Entries)
+}
@@ -0,0 +1,118 @@
+package pref
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// KeySpec declares the constraints on the value of a key in a Schema, the fields not set are not checked.
+type KeySpec struct {
+	// Type is the type of the value, it is the type of Default if not set.
+	Type reflect.Type
+	// Default is the default value of the key.
+	Default interface{}
+	// Min and Max are the range of a numeric value, inclusive.
+	Min interface{}
+	Max interface{}
+	// Enum lists all the allowed values.
+	Enum []interface{}
+	// Validator checks the value by custom rules, and returns an error describing why it is invalid.
+	Validator func(interface{}) error
+}
+
+// Schema declares the keys of a Preferences, so that an editor rejects the values which do not match their
+// declarations. The keys not declared are not checked.
+type Schema struct {
+	specs map[string]KeySpec
+	*sync.RWMutex
+}
+
+// NewSchema creates an empty schema.
+func NewSchema() *Schema {
+	return &Schema{
+		specs:   make(map[string]KeySpec),
+		RWMutex: &sync.RWMutex{},
+	}
+}
+
+// Define declares a key with its constraints.
+func (s *Schema) Define(key string, spec KeySpec) *Schema {
+	s.Lock()
+	defer s.Unlock()
+	if spec.Type == nil && spec.Default != nil {
+		spec.Type = reflect.TypeOf(spec.Default)
+	}
+	s.specs[key] = spec
+	return s
+}
+
+// Spec returns the declaration of a key, and whether the key has been declared.
+func (s *Schema) Spec(key string) (KeySpec, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	spec, exist := s.specs[key]
+	return spec, exist
+}
+
+// Validate checks the value of a key, and returns a *ValidationError if it is invalid. A nil value removes
+// the key and is always valid.
+func (s *Schema) Validate(key string, value interface{}) error {
+	spec, exist := s.Spec(key)
+	if !exist || value == nil {
+		return nil
+	}
+	invalid := func(format string, args ...interface{}) error {
+		return &ValidationError{Key: key, Value: value, Reason: fmt.Sprintf(format, args...)}
+	}
+	if spec.Type != nil && reflect.TypeOf(value) != spec.Type {
+		return invalid("expected type %v but got %T", spec.Type, value)
+	}
+	if spec.Min != nil || spec.Max != nil {
+		v, ok := toFloat64(value)
+		if !ok {
+			return invalid("expected a number but got %T", value)
+		}
+		if min, ok := toFloat64(spec.Min); ok && v < min {
+			return invalid("less than the minimum %v", spec.Min)
+		}
+		if max, ok := toFloat64(spec.Max); ok && v > max {
+			return invalid("greater than the maximum %v", spec.Max)
+		}
+	}
+	if len(spec.Enum) > 0 {
+		allowed := false
+		for _, e := range spec.Enum {
+			if reflect.DeepEqual(e, value) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return invalid("not one of %v", spec.Enum)
+		}
+	}
+	if spec.Validator != nil {
+		if err := spec.Validator(value); err != nil {
+			return invalid("%v", err)
+		}
+	}
+	return nil
+}
+
+// toFloat64 converts a value of any numeric kind to float64.
+func toFloat64(v interface{}) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
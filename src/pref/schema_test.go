@@ -0,0 +1,89 @@
+package pref
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type SchemaTestSuite struct {
+	suite.Suite
+}
+
+func (suite *SchemaTestSuite) SetupTest() {
+	basePath = "./"
+	pref = newPreferencesImpl(PrefName)
+	schema := NewSchema().
+		Define("timeout", KeySpec{Default: 10, Min: 1, Max: 60}).
+		Define("mode", KeySpec{Type: reflect.TypeOf(""), Enum: []interface{}{"fast", "slow"}}).
+		Define("name", KeySpec{Validator: func(v interface{}) error {
+			if s, ok := v.(string); !ok || strings.TrimSpace(s) == "" {
+				return errors.New("must not be blank")
+			}
+			return nil
+		}})
+	WithSchema(schema)(pref)
+}
+
+func (suite *SchemaTestSuite) TearDownTest() {
+	os.Remove(basePath + PrefName)
+	os.Remove(basePath + PrefName + "_bak")
+}
+
+func TestSchemaTestSuite(t *testing.T) {
+	suite.Run(t, new(SchemaTestSuite))
+}
+
+func (suite *SchemaTestSuite) TestValid() {
+	e := pref.Edit().Put("timeout", 30).Put("mode", "fast").Put("name", "pref").Put("other", "30s")
+	suite.True(e.Commit())
+	suite.Nil(e.Err())
+	suite.Equal(pref.GetInt("timeout", 0), 30)
+	suite.True(pref.Edit().Remove("timeout").Commit())
+}
+
+func (suite *SchemaTestSuite) TestInvalidType() {
+	e := pref.Edit().Put("mode", "fast").Put("timeout", "30s")
+	suite.False(e.Commit())
+	err, ok := e.Err().(*ValidationError)
+	suite.True(ok)
+	suite.Equal(err.Key, "timeout")
+	suite.Equal(err.Value, "30s")
+	suite.False(pref.Contains("mode"))
+	suite.False(pref.Contains("timeout"))
+}
+
+func (suite *SchemaTestSuite) TestInvalidValues() {
+	for key, value := range map[string]interface{}{"timeout": 0, "mode": "medium", "name": " "} {
+		e := pref.Edit().Put(key, value)
+		suite.False(e.Commit())
+		suite.Equal(e.Err().(*ValidationError).Key, key)
+	}
+	e := pref.Edit().Put("timeout", 61)
+	suite.Nil(e.Err())
+	e.Apply()
+	suite.Contains(e.Err().Error(), "maximum")
+	suite.Empty(pref.values())
+}
+
+func (suite *SchemaTestSuite) TestIncrement() {
+	suite.True(pref.Edit().Put("timeout", 59).Commit())
+	suite.True(pref.Edit().Increment("timeout", 1).Commit())
+	e := pref.Edit().Increment("timeout", 1)
+	suite.False(e.Commit())
+	suite.Equal(e.Err().(*ValidationError).Value, 61)
+	suite.Equal(pref.GetInt("timeout", 0), 60)
+}
+
+func (suite *SchemaTestSuite) TestUpdate() {
+	err := pref.Update(func(tx Tx) error {
+		tx.Put("timeout", 100)
+		return nil
+	})
+	_, ok := err.(*ValidationError)
+	suite.True(ok)
+	suite.False(pref.Contains("timeout"))
+}
@@ -0,0 +1,325 @@
+package pref
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// legacySuffix is the suffix of the copy of a file written by the earlier versions which cannot be decoded.
+const legacySuffix = ".legacy"
+
+// frameHeaderSize is the size of the length and the checksum before the data of a frame.
+const frameHeaderSize = 8
+
+// errCorruptedFrame is returned when the checksum of a frame does not match its data.
+var errCorruptedFrame = errors.New("pref: corrupted frame")
+
+// Op indicates the kind of change of a Record.
+type Op int
+
+const (
+	// OpPut sets the value of a key.
+	OpPut Op = iota
+	// OpRemove removes a key.
+	OpRemove
+	// OpClear removes all the keys.
+	OpClear
+)
+
+// Record is a single change made by a commit of Editor.
+type Record struct {
+	Op    Op
+	Key   string
+	Value interface{}
+}
+
+// Storage persists the key-values of a Preferences.
+type Storage interface {
+	// Load reads all the key-values from the storage.
+	Load() (map[string]interface{}, error)
+	// Save persists the records of a commit in order, m is the whole key-values after applying the records.
+	Save(m map[string]interface{}, records []Record) error
+}
+
+// applyRecords applies the records to the map in order. Replaying records which have already been
+// applied leaves the map unchanged, since every record only sets the final state of its keys.
+func applyRecords(m map[string]interface{}, records []Record) {
+	for _, r := range records {
+		switch r.Op {
+		case OpPut:
+			m[r.Key] = r.Value
+		case OpRemove:
+			delete(m, r.Key)
+		case OpClear:
+			for k := range m {
+				delete(m, k)
+			}
+		}
+	}
+}
+
+// MigrateStorage copies all the key-values from one storage to another, such as from the default gob file
+// to a key-value database. The destination is cleared before the key-values are written.
+func MigrateStorage(from, to Storage) error {
+	m, err := from.Load()
+	if err != nil {
+		return err
+	}
+	return to.Save(m, replaceRecords(m))
+}
+
+// replaceRecords returns the records which replace the whole content of a storage with m.
+func replaceRecords(m map[string]interface{}) []Record {
+	records := []Record{{Op: OpClear}}
+	for k, v := range m {
+		records = append(records, Record{Op: OpPut, Key: k, Value: v})
+	}
+	return records
+}
+
+// encodedRecord is a Record whose value has been encoded by encodeValue.
+type encodedRecord struct {
+	Op    Op
+	Key   string
+	Value []byte
+}
+
+// encodeValue encodes a single value with gob, the value must be a built-in type or registered by
+// gob.Register. A RawValue is encoded as it was loaded.
+func encodeValue(v interface{}) ([]byte, error) {
+	if raw, ok := v.(RawValue); ok {
+		return raw.data, nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeValue decodes a single value encoded by encodeValue, a value which cannot be decoded is returned
+// as a RawValue, so that it does not fail the other values.
+func decodeValue(data []byte) interface{} {
+	v, err := gobDecodeValue(data)
+	if err != nil {
+		return RawValue{data: data}
+	}
+	return v
+}
+
+func gobDecodeValue(data []byte) (interface{}, error) {
+	var v interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// encodeValues encodes every value of m by encodeValue.
+func encodeValues(m map[string]interface{}) (map[string][]byte, error) {
+	values := make(map[string][]byte)
+	for k, v := range m {
+		data, err := encodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("pref: cannot encode key %s: %v", k, err)
+		}
+		values[k] = data
+	}
+	return values, nil
+}
+
+// decodeValues decodes every value of values by decodeValue.
+func decodeValues(values map[string][]byte) map[string]interface{} {
+	m := make(map[string]interface{})
+	for k, data := range values {
+		m[k] = decodeValue(data)
+	}
+	return m
+}
+
+// encodeRecords encodes the values of records by encodeValue.
+func encodeRecords(records []Record) ([]encodedRecord, error) {
+	encoded := make([]encodedRecord, len(records))
+	for i, r := range records {
+		encoded[i] = encodedRecord{Op: r.Op, Key: r.Key}
+		if r.Op == OpPut {
+			data, err := encodeValue(r.Value)
+			if err != nil {
+				return nil, fmt.Errorf("pref: cannot encode key %s: %v", r.Key, err)
+			}
+			encoded[i].Value = data
+		}
+	}
+	return encoded, nil
+}
+
+// decodeRecords decodes the values of records by decodeValue.
+func decodeRecords(encoded []encodedRecord) []Record {
+	records := make([]Record, len(encoded))
+	for i, r := range encoded {
+		records[i] = Record{Op: r.Op, Key: r.Key}
+		if r.Op == OpPut {
+			records[i].Value = decodeValue(r.Value)
+		}
+	}
+	return records
+}
+
+// frame wraps data with its length and CRC32 checksum, so that a frame torn by a crash is detected
+// when it is read.
+func frame(data []byte) []byte {
+	f := make([]byte, frameHeaderSize, frameHeaderSize+len(data))
+	binary.BigEndian.PutUint32(f[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(f[4:8], crc32.ChecksumIEEE(data))
+	return append(f, data...)
+}
+
+// readFrame reads the data of next frame from r.
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	// Copy instead of allocating the whole length up front, the length of a torn frame may be garbage.
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(binary.BigEndian.Uint32(header[0:4]))); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errCorruptedFrame
+	}
+	return data, nil
+}
+
+// fileStorage writes the whole map to a gob file on every save, the previous file is kept as a backup
+// until the new one has been written successfully. Every value is encoded separately in fileContent, so a
+// value which cannot be decoded does not fail the others.
+type fileStorage struct {
+	path string
+	// generation is stored with the map, it is set by the log storage to discard the frames of its log
+	// which are older than the snapshot.
+	generation uint64
+	// stamp is the fingerprint of the file when it was last loaded or saved.
+	stamp string
+}
+
+// fileContent is the content of the file of fileStorage.
+type fileContent struct {
+	Values     map[string][]byte
+	Generation uint64
+}
+
+func newFileStorage(path string) *fileStorage {
+	return &fileStorage{path: path}
+}
+
+// Load reads the map from file, and recovers from the backup file if the last write did not complete.
+func (s *fileStorage) Load() (map[string]interface{}, error) {
+	defer s.updateStamp()
+	backupPath := s.path + "_bak"
+	// Load backup file if exists.
+	if _, err := os.Stat(backupPath); err == nil {
+		os.Remove(s.path)
+		os.Rename(backupPath, s.path)
+	}
+	m := make(map[string]interface{})
+	s.generation = 0
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var content fileContent
+	if err := gob.NewDecoder(file).Decode(&content); err == nil {
+		s.generation = content.Generation
+		return decodeValues(content.Values), nil
+	}
+	// Fall back to the file written by the earlier versions, which encoded the map as a whole.
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := gob.NewDecoder(file).Decode(&m); err != nil {
+		// A value of a type which has not been registered fails the whole map, and the file would be replaced
+		// by the next save, so it is copied aside untouched to be loaded again after registering the type.
+		legacyPath := s.path + legacySuffix
+		if _, statErr := os.Stat(legacyPath); os.IsNotExist(statErr) {
+			if copyErr := copyFile(s.path, legacyPath); copyErr != nil {
+				return nil, fmt.Errorf("pref: cannot decode %s: %v, and cannot copy it: %v", s.path, err, copyErr)
+			}
+		}
+		return nil, fmt.Errorf("pref: cannot decode %s, it is copied to %s: %v", s.path, legacyPath, err)
+	}
+	return m, nil
+}
+
+// copyFile copies the file at path to newPath.
+func copyFile(path, newPath string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return writeFile(newPath, data)
+}
+
+// Save writes the whole map to file, the records are ignored.
+func (s *fileStorage) Save(m map[string]interface{}, records []Record) error {
+	defer s.updateStamp()
+	backupPath := s.path + "_bak"
+	// Backup the normal file
+	if _, err := os.Stat(s.path); err == nil {
+		if _, err2 := os.Stat(backupPath); err2 == nil {
+			os.Remove(s.path)
+		} else {
+			os.Rename(s.path, backupPath)
+		}
+	}
+	file, err := os.Create(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	values, err := encodeValues(m)
+	if err == nil {
+		err = gob.NewEncoder(file).Encode(&fileContent{Values: values, Generation: s.generation})
+	}
+	if err != nil {
+		// remove normal file if error
+		os.Remove(s.path)
+		return err
+	}
+	// remove backup file if success
+	os.Remove(backupPath)
+	return nil
+}
+
+// Changed returns whether the file has been modified since it was last loaded or saved.
+func (s *fileStorage) Changed() bool {
+	return fingerprint(s.path) != s.stamp
+}
+
+func (s *fileStorage) updateStamp() {
+	s.stamp = fingerprint(s.path)
+}
+
+// fingerprint returns the sizes and modification times of the files, which change when the files are
+// written by others. A file which does not exist has an empty fingerprint.
+func fingerprint(paths ...string) string {
+	parts := make([]string, len(paths))
+	for i, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			parts[i] = fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return strings.Join(parts, "\n")
+}
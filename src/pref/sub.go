@@ -0,0 +1,248 @@
+package pref
+
+import (
+	"strings"
+	"time"
+)
+
+// subSeparator separates a namespace from the keys in it.
+const subSeparator = "."
+
+// subBufferSize is the extra buffer of the channels which forward the events to the listeners of Sub.
+const subBufferSize = 64
+
+// subPreferences is a view of the keys of a Preferences in a namespace, see Sub.
+type subPreferences struct {
+	TypedGetters
+	p      *PreferencesImpl
+	prefix string
+}
+
+// subListener identifies a listener registered through the views of a namespace, the views are created
+// by every call of Sub so the forwarders are kept by the Preferences.
+type subListener struct {
+	observer OnPreferenceChangeListener
+	prefix   string
+}
+
+// Sub returns a view of the keys of the Preferences in a namespace, the keys read and written through the
+// view are prefixed by the namespace and a dot transparently, such as "proxy" for "net.proxy" in the
+// namespace "net". The Clear of its editors only removes the keys in the namespace, and its listeners are
+// only notified of the keys in the namespace, without the prefix. Its revision is the one of the whole
+// Preferences. The view of a nested namespace is got by calling Sub on the view.
+func (p *PreferencesImpl) Sub(namespace string) Preferences {
+	return newSubPreferences(p, namespace+subSeparator)
+}
+
+func newSubPreferences(p *PreferencesImpl, prefix string) *subPreferences {
+	s := &subPreferences{p: p, prefix: prefix}
+	s.TypedGetters = TypedGetters{s.GetObject}
+	return s
+}
+
+// Sub returns a view of a namespace nested in this one.
+func (s *subPreferences) Sub(namespace string) Preferences {
+	return newSubPreferences(s.p, s.prefix+namespace+subSeparator)
+}
+
+func (s *subPreferences) Contains(key string) bool {
+	return s.p.Contains(s.prefix + key)
+}
+
+func (s *subPreferences) GetObject(key string, defaultValue interface{}) interface{} {
+	return s.p.GetObject(s.prefix+key, defaultValue)
+}
+
+// GetAll returns a copy of the key-values in the namespace without the prefix.
+func (s *subPreferences) GetAll() map[string]interface{} {
+	all := make(map[string]interface{})
+	for k, v := range s.p.GetAll() {
+		if strings.HasPrefix(k, s.prefix) {
+			all[strings.TrimPrefix(k, s.prefix)] = v
+		}
+	}
+	return all
+}
+
+func (s *subPreferences) Revision() uint64 {
+	return s.p.Revision()
+}
+
+// Epoch returns the epoch of the whole Preferences, see PreferencesImpl.Epoch.
+func (s *subPreferences) Epoch() string {
+	return s.p.Epoch()
+}
+
+// SetDefaults registers the default values of the keys in the namespace.
+func (s *subPreferences) SetDefaults(defaults map[string]interface{}) {
+	prefixed := make(map[string]interface{}, len(defaults))
+	for k, v := range defaults {
+		prefixed[s.prefix+k] = v
+	}
+	s.p.SetDefaults(prefixed)
+}
+
+func (s *subPreferences) GetOrDefault(key string) interface{} {
+	return s.p.GetOrDefault(s.prefix + key)
+}
+
+func (s *subPreferences) IsDefault(key string) bool {
+	return s.p.IsDefault(s.prefix + key)
+}
+
+// RegisterOnPreferenceChangeListener registers a listener of the keys in the namespace, which receives
+// the keys without the prefix. It is registered once per namespace, and can be unregistered through any
+// view of the namespace.
+func (s *subPreferences) RegisterOnPreferenceChangeListener(observer OnPreferenceChangeListener) {
+	if observer == nil {
+		return
+	}
+	listener := subListener{observer: observer, prefix: s.prefix}
+	s.p.forwardLock.Lock()
+	defer s.p.forwardLock.Unlock()
+	if _, exist := s.p.forwarders[listener]; exist {
+		return
+	}
+	// The channel receives the keys of all the namespaces, so it buffers more than observer.
+	ch := make(OnPreferenceChangeListener, cap(observer)+subBufferSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for key := range ch {
+			if !strings.HasPrefix(key, s.prefix) {
+				continue
+			}
+			select {
+			case observer <- strings.TrimPrefix(key, s.prefix):
+			default:
+			}
+		}
+	}()
+	s.p.RegisterOnPreferenceChangeListener(ch)
+	s.p.forwarders[listener] = func() {
+		s.p.UnregisterOnPreferenceChangeListener(ch)
+		close(ch)
+		<-done
+	}
+}
+
+// UnregisterOnPreferenceChangeListener unregisters a listener, no more key is sent to it after it returns.
+func (s *subPreferences) UnregisterOnPreferenceChangeListener(observer OnPreferenceChangeListener) {
+	listener := subListener{observer: observer, prefix: s.prefix}
+	s.p.forwardLock.Lock()
+	defer s.p.forwardLock.Unlock()
+	if stop, exist := s.p.forwarders[listener]; exist {
+		stop()
+		delete(s.p.forwarders, listener)
+	}
+}
+
+// Edit creates an editor of the keys in the namespace.
+func (s *subPreferences) Edit() Editor {
+	return &subEditor{e: s.p.Edit().(*EditorImpl), prefix: s.prefix}
+}
+
+// EditAt creates an editor of the keys in the namespace based on the given revision of the whole
+// Preferences.
+func (s *subPreferences) EditAt(revision uint64) Editor {
+	return &subEditor{e: s.p.EditAt(revision).(*EditorImpl), prefix: s.prefix}
+}
+
+// Update runs fn in a transaction of the keys in the namespace.
+func (s *subPreferences) Update(fn func(Tx) error) error {
+	return s.p.Update(func(tx Tx) error {
+		return fn(newSubTx(tx.(*txImpl), s.prefix))
+	})
+}
+
+// subEditor is an Editor of the keys in a namespace.
+type subEditor struct {
+	e      *EditorImpl
+	prefix string
+}
+
+func (e *subEditor) Apply() {
+	e.e.Apply()
+}
+
+func (e *subEditor) Commit() bool {
+	return e.e.Commit()
+}
+
+// Clear removes the keys in the namespace only.
+func (e *subEditor) Clear() Editor {
+	e.e.clearPrefix(e.prefix)
+	return e
+}
+
+func (e *subEditor) Remove(key string) Editor {
+	e.e.Remove(e.prefix + key)
+	return e
+}
+
+func (e *subEditor) Put(key string, value interface{}) Editor {
+	e.e.Put(e.prefix+key, value)
+	return e
+}
+
+func (e *subEditor) CompareAndPut(key string, expected interface{}, value interface{}) Editor {
+	e.e.CompareAndPut(e.prefix+key, expected, value)
+	return e
+}
+
+func (e *subEditor) Increment(key string, delta int64) Editor {
+	e.e.Increment(e.prefix+key, delta)
+	return e
+}
+
+func (e *subEditor) PutWithTTL(key string, value interface{}, ttl time.Duration) Editor {
+	e.e.PutWithTTL(e.prefix+key, value, ttl)
+	return e
+}
+
+func (e *subEditor) Err() error {
+	return e.e.Err()
+}
+
+// subTx is a Tx of the keys in a namespace.
+type subTx struct {
+	TypedGetters
+	tx     *txImpl
+	prefix string
+}
+
+func newSubTx(tx *txImpl, prefix string) *subTx {
+	t := &subTx{tx: tx, prefix: prefix}
+	t.TypedGetters = TypedGetters{t.GetObject}
+	return t
+}
+
+func (t *subTx) Contains(key string) bool {
+	return t.tx.Contains(t.prefix + key)
+}
+
+func (t *subTx) GetObject(key string, defaultValue interface{}) interface{} {
+	return t.tx.GetObject(t.prefix+key, defaultValue)
+}
+
+func (t *subTx) Put(key string, value interface{}) Tx {
+	t.tx.Put(t.prefix+key, value)
+	return t
+}
+
+func (t *subTx) Remove(key string) Tx {
+	t.tx.Remove(t.prefix + key)
+	return t
+}
+
+// Clear removes the keys in the namespace only in the transaction.
+func (t *subTx) Clear() Tx {
+	// Forget the writes in the namespace before clear, as txImpl.Clear does for all the keys.
+	for k := range t.tx.editor.modified {
+		if strings.HasPrefix(k, t.prefix) {
+			delete(t.tx.editor.modified, k)
+		}
+	}
+	t.tx.editor.clearPrefix(t.prefix)
+	return t
+}
@@ -0,0 +1,112 @@
+package pref
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"testing"
+)
+
+type SubTestSuite struct {
+	suite.Suite
+}
+
+func (suite *SubTestSuite) SetupTest() {
+	basePath = "./"
+	pref = newPreferencesImpl(PrefName)
+}
+
+func (suite *SubTestSuite) TearDownTest() {
+	os.Remove(basePath + PrefName)
+	os.Remove(basePath + PrefName + "_bak")
+}
+
+func TestSubTestSuite(t *testing.T) {
+	suite.Run(t, new(SubTestSuite))
+}
+
+func (suite *SubTestSuite) TestPrefix() {
+	net := pref.Sub("net")
+	suite.True(net.Edit().Put("proxy", "localhost").Put("port", 8080).Commit())
+	suite.True(pref.Edit().Put("ui.theme", "dark").Commit())
+	suite.Equal("localhost", pref.GetString("net.proxy", ""))
+	suite.Equal(8080, net.GetInt("port", 0))
+	suite.True(net.Contains("proxy"))
+	suite.False(net.Contains("ui.theme"))
+	suite.Equal(map[string]interface{}{"proxy": "localhost", "port": 8080}, net.GetAll())
+
+	net.SetDefaults(map[string]interface{}{"timeout": 30})
+	suite.Equal(30, pref.GetOrDefault("net.timeout"))
+	suite.True(net.IsDefault("timeout"))
+
+	http := net.(*subPreferences).Sub("http")
+	suite.True(http.Edit().Put("agent", "go").Commit())
+	suite.Equal("go", pref.GetString("net.http.agent", ""))
+	suite.Equal("go", net.GetString("http.agent", ""))
+}
+
+func (suite *SubTestSuite) TestScopedClear() {
+	suite.True(pref.Edit().Put("net.proxy", "localhost").Put("net.port", 8080).Put("ui.theme", "dark").Put("network", 1).Commit())
+	net := pref.Sub("net")
+	suite.True(net.Edit().Clear().Put("port", 9090).Commit())
+	suite.Equal(map[string]interface{}{"net.port": 9090, "ui.theme": "dark", "network": 1}, pref.GetAll())
+
+	pref = load(PrefName)
+	suite.Equal(map[string]interface{}{"net.port": 9090, "ui.theme": "dark", "network": 1}, pref.GetAll())
+}
+
+func (suite *SubTestSuite) TestListener() {
+	net := pref.Sub("net")
+	ch := make(chan string, 2)
+	net.RegisterOnPreferenceChangeListener(ch)
+	suite.True(pref.Edit().Put("ui.theme", "dark").Commit())
+	suite.True(pref.Edit().Put("net.proxy", "localhost").Commit())
+	suite.Equal("proxy", <-ch)
+
+	net.UnregisterOnPreferenceChangeListener(ch)
+	close(ch)
+	suite.True(pref.Edit().Put("net.proxy", "remote").Commit())
+	suite.Empty(pref.observers)
+}
+
+func (suite *SubTestSuite) TestListenerOfAnotherView() {
+	ch := make(chan string, 2)
+	pref.Sub("net").RegisterOnPreferenceChangeListener(ch)
+	pref.Sub("net").RegisterOnPreferenceChangeListener(ch)
+	suite.Len(pref.observers, 1)
+	pref.Sub("ui").RegisterOnPreferenceChangeListener(ch)
+	suite.Len(pref.observers, 2)
+
+	// The listener is unregistered through another view of the same namespace.
+	pref.Sub("net").UnregisterOnPreferenceChangeListener(ch)
+	suite.Len(pref.observers, 1)
+	suite.True(pref.Edit().Put("net.proxy", "localhost").Put("ui.theme", "dark").Commit())
+	suite.Equal("theme", <-ch)
+	pref.Sub("ui").UnregisterOnPreferenceChangeListener(ch)
+	close(ch)
+	suite.True(pref.Edit().Put("net.proxy", "remote").Put("ui.theme", "light").Commit())
+	suite.Empty(pref.observers)
+	suite.Empty(pref.forwarders)
+}
+
+func (suite *SubTestSuite) TestUpdate() {
+	suite.True(pref.Edit().Put("net.proxy", "localhost").Put("ui.theme", "dark").Commit())
+	net := pref.Sub("net")
+	suite.NoError(net.Update(func(tx Tx) error {
+		suite.Equal("localhost", tx.GetString("proxy", ""))
+		tx.Put("port", 1).Clear()
+		suite.False(tx.Contains("proxy"))
+		suite.False(tx.Contains("port"))
+		tx.Put("port", 2)
+		suite.Equal(2, tx.GetInt("port", 0))
+		return nil
+	}))
+	suite.Equal(map[string]interface{}{"net.port": 2, "ui.theme": "dark"}, pref.GetAll())
+
+	err := errors.New("abort")
+	suite.Equal(err, net.Update(func(tx Tx) error {
+		tx.Clear()
+		return err
+	}))
+	suite.Equal(2, net.GetInt("port", 0))
+}
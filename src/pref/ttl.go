@@ -0,0 +1,101 @@
+package pref
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"time"
+)
+
+func init() {
+	gob.Register(expiringValue{})
+	registerDirCodec("expiring", expiringValue{}, dirCodec{format: formatExpiring, parse: parseExpiring})
+}
+
+// expiringValue wraps a value put by PutWithTTL with its deadline, it is stored in place of the value so
+// the deadline is persisted by any storage which can encode it.
+type expiringValue struct {
+	Value    interface{}
+	Deadline time.Time
+}
+
+// expiringText is the text of an expiringValue in the dir storage, the value is formatted by FormatValue.
+type expiringText struct {
+	Type     string
+	Value    string
+	Deadline time.Time
+}
+
+func formatExpiring(v interface{}) (string, error) {
+	ev := v.(expiringValue)
+	typeName, text, err := FormatValue(ev.Value)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(&expiringText{Type: typeName, Value: text, Deadline: ev.Deadline})
+	return string(data), err
+}
+
+func parseExpiring(text string) (interface{}, error) {
+	var et expiringText
+	if err := json.Unmarshal([]byte(text), &et); err != nil {
+		return nil, err
+	}
+	v, err := ParseValue(et.Type, et.Value)
+	if err != nil {
+		return nil, err
+	}
+	return expiringValue{Value: v, Deadline: et.Deadline}, nil
+}
+
+// WithClock sets the function which returns the current time to decide whether a key has expired, it is
+// time.Now by default.
+func WithClock(now func() time.Time) Option {
+	return func(p *PreferencesImpl) {
+		p.now = now
+	}
+}
+
+// PutWithTTL sets the value of key which expires after ttl from now. An expired key is invisible to the
+// getters, and it is removed by the next commit of the Preferences, which notifies the listeners.
+func (e *EditorImpl) PutWithTTL(key string, value interface{}, ttl time.Duration) Editor {
+	e.Lock()
+	defer e.Unlock()
+	if !e.validateLocked(key, value) {
+		return e
+	}
+	registerType(value)
+	e.modified[key] = expiringValue{Value: value, Deadline: e.pref.now().Add(ttl)}
+	delete(e.increments, key)
+	return e
+}
+
+// lookup returns the value of key in m, the expired value is treated as not existing.
+func (p *PreferencesImpl) lookup(m map[string]interface{}, key string) (interface{}, bool) {
+	v, exist := m[key]
+	if !exist {
+		return nil, false
+	}
+	return p.unwrap(v)
+}
+
+// unwrap returns the value wrapped by expiringValue, and whether it has not expired.
+func (p *PreferencesImpl) unwrap(v interface{}) (interface{}, bool) {
+	if ev, ok := v.(expiringValue); ok {
+		if !p.now().Before(ev.Deadline) {
+			return nil, false
+		}
+		return ev.Value, true
+	}
+	return v, true
+}
+
+// expiredKeys returns the keys in m which have expired.
+func (p *PreferencesImpl) expiredKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0)
+	for k, v := range m {
+		if _, live := p.unwrap(v); !live {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,123 @@
+package pref
+
+import (
+	"github.com/stretchr/testify/suite"
+	"os"
+	"testing"
+	"time"
+)
+
+type TTLTestSuite struct {
+	suite.Suite
+	clock time.Time
+}
+
+func (suite *TTLTestSuite) SetupTest() {
+	basePath = "./"
+	suite.clock = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	pref = newPreferencesImpl(PrefName)
+	WithClock(suite.now)(pref)
+}
+
+func (suite *TTLTestSuite) TearDownTest() {
+	os.Remove(basePath + PrefName)
+	os.Remove(basePath + PrefName + "_bak")
+}
+
+func TestTTLTestSuite(t *testing.T) {
+	suite.Run(t, new(TTLTestSuite))
+}
+
+func (suite *TTLTestSuite) now() time.Time {
+	return suite.clock
+}
+
+func (suite *TTLTestSuite) TestExpire() {
+	suite.True(pref.Edit().PutWithTTL("token", "abc", time.Minute).Commit())
+	suite.True(pref.Contains("token"))
+	suite.Equal("abc", pref.GetString("token", ""))
+
+	suite.clock = suite.clock.Add(time.Minute)
+	suite.False(pref.Contains("token"))
+	suite.Equal("none", pref.GetString("token", "none"))
+	suite.True(pref.IsDefault("token"))
+}
+
+func (suite *TTLTestSuite) TestPurgeOnCommit() {
+	suite.True(pref.Edit().PutWithTTL("token", "abc", time.Minute).Put("key", 1).Commit())
+	ch := make(chan string, 2)
+	pref.RegisterOnPreferenceChangeListener(ch)
+	suite.clock = suite.clock.Add(time.Hour)
+
+	suite.True(pref.Edit().Put("other", 2).Commit())
+	_, exist := pref.values()["token"]
+	suite.False(exist)
+	keys := []string{<-ch, <-ch}
+	suite.Contains(keys, "token")
+	suite.Contains(keys, "other")
+
+	pref = load(PrefName)
+	suite.Len(pref.values(), 2)
+	suite.Equal(1, pref.GetInt("key", 0))
+}
+
+func (suite *TTLTestSuite) TestPersistDeadline() {
+	suite.True(pref.Edit().PutWithTTL("token", "abc", time.Minute).Commit())
+
+	pref = load(PrefName)
+	WithClock(suite.now)(pref)
+	suite.Equal("abc", pref.GetString("token", ""))
+	suite.clock = suite.clock.Add(time.Minute)
+	suite.Equal("", pref.GetString("token", ""))
+}
+
+func (suite *TTLTestSuite) TestStorages() {
+	for name, option := range storageOptions {
+		pref = newPreferencesImpl(PrefName)
+		option(pref)
+		WithClock(suite.now)(pref)
+		suite.True(pref.Edit().PutWithTTL("token", "abc", time.Minute).PutWithTTL("count", 1, time.Hour).Commit(), name)
+
+		pref = load(PrefName, option, WithClock(suite.now))
+		suite.Equal("abc", pref.GetString("token", ""), name)
+		suite.Equal(1, pref.GetInt("count", 0), name)
+		suite.clock = suite.clock.Add(time.Minute)
+		suite.False(pref.Contains("token"), name)
+		suite.True(pref.Contains("count"), name)
+		removeStorages(PrefName)
+	}
+}
+
+func (suite *TTLTestSuite) TestPutAgainKeepsKey() {
+	suite.True(pref.Edit().PutWithTTL("token", "abc", time.Minute).Commit())
+	suite.clock = suite.clock.Add(time.Hour)
+	suite.True(pref.Edit().PutWithTTL("token", "def", time.Minute).Commit())
+	suite.Equal("def", pref.GetString("token", ""))
+	suite.True(pref.Edit().Put("token", "forever").Commit())
+	suite.clock = suite.clock.Add(time.Hour)
+	suite.Equal("forever", pref.GetString("token", ""))
+}
+
+func (suite *TTLTestSuite) TestConditionsOnExpiredKey() {
+	suite.True(pref.Edit().PutWithTTL("lock", "owner", time.Minute).PutWithTTL("count", 5, time.Minute).Commit())
+	suite.False(pref.Edit().CompareAndPut("lock", nil, "other").Commit())
+
+	suite.clock = suite.clock.Add(time.Minute)
+	suite.True(pref.Edit().CompareAndPut("lock", nil, "other").Increment("count", 1).Commit())
+	suite.Equal("other", pref.GetString("lock", ""))
+	suite.Equal(1, pref.GetInt("count", 0))
+}
+
+func (suite *TTLTestSuite) TestIncrementKeepsDeadline() {
+	suite.True(pref.Edit().PutWithTTL("count", 1, time.Minute).Commit())
+	suite.True(pref.Edit().Increment("count", 2).Commit())
+	suite.Equal(3, pref.GetInt("count", 0))
+	suite.True(pref.Edit().PutWithTTL("other", 1, time.Minute).Increment("other", 1).Commit())
+	suite.Equal(2, pref.GetInt("other", 0))
+
+	suite.clock = suite.clock.Add(time.Minute)
+	suite.False(pref.Contains("count"))
+	suite.False(pref.Contains("other"))
+	suite.True(pref.Edit().Increment("count", 5).Commit())
+	suite.Equal(5, pref.GetInt("count", 0))
+}
@@ -0,0 +1,86 @@
+package pref
+
+// txImpl is a Tx which keeps its writes in an editor until the transaction is committed.
+type txImpl struct {
+	TypedGetters
+	editor *EditorImpl
+	base   map[string]interface{}
+}
+
+// Update runs fn in a transaction, which reads and writes the Preferences under the lock of writers. The
+// writes of the transaction are committed like Commit if fn returns nil, and discarded if fn returns an
+// error, which is returned by Update. The tx must not be used after fn returns.
+func (p *PreferencesImpl) Update(fn func(Tx) error) error {
+	p.loadWg.Wait()
+	e := newEditorImpl(p)
+	p.Lock()
+	tx := &txImpl{editor: e, base: p.values()}
+	tx.TypedGetters = TypedGetters{tx.GetObject}
+	err := func() error {
+		defer func() {
+			if r := recover(); r != nil {
+				p.Unlock()
+				panic(r)
+			}
+		}()
+		return fn(tx)
+	}()
+	if err != nil {
+		p.Unlock()
+		return err
+	}
+	e.commitAndUnlock()
+	return e.err
+}
+
+// Contains returns whether a key exists in the Preferences with the writes of the transaction.
+func (tx *txImpl) Contains(key string) bool {
+	_, exist := tx.get(key)
+	return exist
+}
+
+// GetObject returns the object value with the writes of the transaction, and return default value if the
+// key has not been set. The default value registered by SetDefaults takes precedence over the given one.
+func (tx *txImpl) GetObject(key string, defaultValue interface{}) interface{} {
+	if v, exist := tx.get(key); exist {
+		return v
+	}
+	if registered, exist := tx.editor.pref.defaultValue(key); exist {
+		return registered
+	}
+	return defaultValue
+}
+
+func (tx *txImpl) get(key string) (interface{}, bool) {
+	if v, exist := tx.editor.modified[key]; exist {
+		// A nil value indicates the key has been removed.
+		if v == nil {
+			return nil, false
+		}
+		return tx.editor.pref.unwrap(v)
+	}
+	if tx.editor.clearedKey(key) {
+		return nil, false
+	}
+	return tx.editor.pref.lookup(tx.base, key)
+}
+
+// Put sets the value of key in the transaction.
+func (tx *txImpl) Put(key string, value interface{}) Tx {
+	tx.editor.Put(key, value)
+	return tx
+}
+
+// Remove removes the key in the transaction.
+func (tx *txImpl) Remove(key string) Tx {
+	tx.editor.Remove(key)
+	return tx
+}
+
+// Clear removes all the keys in the transaction.
+func (tx *txImpl) Clear() Tx {
+	// Forget the writes before clear, as Editor.Clear would keep them.
+	tx.editor.modified = make(map[string]interface{})
+	tx.editor.Clear()
+	return tx
+}
@@ -0,0 +1,100 @@
+package pref
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"testing"
+)
+
+type TxTestSuite struct {
+	suite.Suite
+}
+
+func (suite *TxTestSuite) SetupTest() {
+	basePath = "./"
+	pref = newPreferencesImpl(PrefName)
+}
+
+func (suite *TxTestSuite) TearDownTest() {
+	os.Remove(basePath + PrefName)
+	os.Remove(basePath + PrefName + "_bak")
+}
+
+func TestTxTestSuite(t *testing.T) {
+	suite.Run(t, new(TxTestSuite))
+}
+
+func (suite *TxTestSuite) TestUpdate() {
+	pref.Edit().Put("key1", 1).Put("key2", "value").Commit()
+	err := pref.Update(func(tx Tx) error {
+		tx.Put("key1", tx.GetInt("key1", 0)+1)
+		suite.Equal(tx.GetInt("key1", 0), 2)
+		tx.Remove("key2")
+		suite.False(tx.Contains("key2"))
+		suite.Equal(tx.GetString("key2", "default"), "default")
+		return nil
+	})
+	suite.Nil(err)
+	suite.Equal(pref.GetInt("key1", 0), 2)
+	suite.False(pref.Contains("key2"))
+	_, err = os.Stat(basePath + PrefName)
+	suite.Nil(err)
+}
+
+func (suite *TxTestSuite) TestRollback() {
+	pref.Edit().Put("key", 1).Commit()
+	revision := pref.Revision()
+	txErr := errors.New("rollback")
+	err := pref.Update(func(tx Tx) error {
+		tx.Put("key", 2).Put("other", 3)
+		return txErr
+	})
+	suite.Equal(err, txErr)
+	suite.Equal(pref.GetInt("key", 0), 1)
+	suite.False(pref.Contains("other"))
+	suite.Equal(pref.Revision(), revision)
+}
+
+func (suite *TxTestSuite) TestClear() {
+	pref.Edit().Put("key1", 1).Put("key2", 2).Commit()
+	pref.Update(func(tx Tx) error {
+		tx.Put("key3", 3).Clear().Put("key2", 4)
+		suite.False(tx.Contains("key1"))
+		suite.False(tx.Contains("key3"))
+		suite.Equal(tx.GetInt("key2", 0), 4)
+		return nil
+	})
+	suite.False(pref.Contains("key1"))
+	suite.False(pref.Contains("key3"))
+	suite.Equal(pref.GetInt("key2", 0), 4)
+}
+
+func (suite *TxTestSuite) TestPanic() {
+	suite.Panics(func() {
+		pref.Update(func(tx Tx) error {
+			tx.Put("key", 1)
+			panic("panic in transaction")
+		})
+	})
+	suite.False(pref.Contains("key"))
+	suite.True(pref.Edit().Put("key", 2).Commit())
+}
+
+func (suite *TxTestSuite) TestConcurrency() {
+	ch := make(chan bool, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			for i := 0; i < 100; i++ {
+				pref.Update(func(tx Tx) error {
+					tx.Put("count", tx.GetInt("count", 0)+1)
+					return nil
+				})
+			}
+			ch <- true
+		}()
+	}
+	<-ch
+	<-ch
+	suite.Equal(pref.GetInt("count", 0), 200)
+}
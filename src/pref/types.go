@@ -0,0 +1,95 @@
+package pref
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// TypeName returns the name of the type of a value supported by the typed getters of Preferences, such as
+// "int" or "string". It returns an empty string for any other type.
+func TypeName(v interface{}) string {
+	switch v.(type) {
+	case bool:
+		return "bool"
+	case int:
+		return "int"
+	case int32:
+		return "int32"
+	case int64:
+		return "int64"
+	case uint32:
+		return "uint32"
+	case uint64:
+		return "uint64"
+	case float32:
+		return "float32"
+	case float64:
+		return "float64"
+	case byte:
+		return "byte"
+	case string:
+		return "string"
+	}
+	return ""
+}
+
+// FormatValue formats a value supported by the typed getters as text, which can be parsed back by
+// ParseValue with the type name.
+func FormatValue(v interface{}) (typeName string, text string, err error) {
+	typeName = TypeName(v)
+	switch val := v.(type) {
+	case float32:
+		return typeName, strconv.FormatFloat(float64(val), 'g', -1, 32), nil
+	case float64:
+		return typeName, strconv.FormatFloat(val, 'g', -1, 64), nil
+	}
+	if typeName == "" {
+		return "", "", fmt.Errorf("pref: unsupported type %T", v)
+	}
+	return typeName, fmt.Sprint(v), nil
+}
+
+// ParseValue parses the text into a value of the named type, which is one returned by TypeName. A rune
+// is parsed as "int32" since they are the same type.
+func ParseValue(typeName string, text string) (interface{}, error) {
+	var v interface{}
+	var err error
+	switch typeName {
+	case "bool":
+		v, err = strconv.ParseBool(text)
+	case "int":
+		var i int64
+		i, err = strconv.ParseInt(text, 10, strconv.IntSize)
+		v = int(i)
+	case "int32", "rune":
+		var i int64
+		i, err = strconv.ParseInt(text, 10, 32)
+		v = int32(i)
+	case "int64":
+		v, err = strconv.ParseInt(text, 10, 64)
+	case "uint32":
+		var u uint64
+		u, err = strconv.ParseUint(text, 10, 32)
+		v = uint32(u)
+	case "uint64":
+		v, err = strconv.ParseUint(text, 10, 64)
+	case "float32":
+		var f float64
+		f, err = strconv.ParseFloat(text, 32)
+		v = float32(f)
+	case "float64":
+		v, err = strconv.ParseFloat(text, 64)
+	case "byte":
+		var u uint64
+		u, err = strconv.ParseUint(text, 10, 8)
+		v = byte(u)
+	case "string", "":
+		v = text
+	default:
+		return nil, fmt.Errorf("pref: unknown type %q", typeName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,89 @@
+package prefhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pref"
+	"time"
+)
+
+// Op is an operation of a batch, which is applied to the editor by its name:
+//
+//	{"op": "put", "key": k, "value": v, "type": t}                  Editor.Put
+//	{"op": "remove", "key": k}                                       Editor.Remove
+//	{"op": "clear"}                                                  Editor.Clear
+//	{"op": "cas", "key": k, "expected": e, "value": v, "type": t}    Editor.CompareAndPut
+//	{"op": "incr", "key": k, "delta": d}                             Editor.Increment
+//	{"op": "ttl", "key": k, "value": v, "type": t, "ttl": "10s"}     Editor.PutWithTTL
+//
+// The expected value of cas is decoded with the same type as the value, and a missing or null expected
+// value means the key must not exist.
+type Op struct {
+	Op       string          `json:"op"`
+	Key      string          `json:"key,omitempty"`
+	Value    json.RawMessage `json:"value,omitempty"`
+	Type     string          `json:"type,omitempty"`
+	Expected json.RawMessage `json:"expected,omitempty"`
+	Delta    int64           `json:"delta,omitempty"`
+	TTL      string          `json:"ttl,omitempty"`
+}
+
+// serveBatch applies the operations in the body to one editor, and commits them at once.
+func serveBatch(w http.ResponseWriter, r *http.Request, p pref.Preferences) error {
+	var ops []Op
+	if err := decodeBody(r, &ops); err != nil {
+		return err
+	}
+	editor, err := editorOf(r, p)
+	if err != nil {
+		return err
+	}
+	for i, op := range ops {
+		if err := applyOp(editor, op); err != nil {
+			return errorf(http.StatusBadRequest, "invalid operation %d: %v", i, err)
+		}
+	}
+	return commit(w, p, editor)
+}
+
+func applyOp(editor pref.Editor, op Op) error {
+	if op.Op != "clear" && op.Key == "" {
+		return errors.New("missing key")
+	}
+	switch op.Op {
+	case "put", "cas", "ttl":
+		v, err := DecodeValue(op.Value, op.Type)
+		if err != nil {
+			return err
+		}
+		switch op.Op {
+		case "put":
+			editor.Put(op.Key, v)
+		case "cas":
+			var expected interface{}
+			if len(op.Expected) > 0 && string(op.Expected) != "null" {
+				if expected, err = DecodeValue(op.Expected, op.Type); err != nil {
+					return err
+				}
+			}
+			editor.CompareAndPut(op.Key, expected, v)
+		case "ttl":
+			ttl, err := time.ParseDuration(op.TTL)
+			if err != nil {
+				return err
+			}
+			editor.PutWithTTL(op.Key, v, ttl)
+		}
+	case "remove":
+		editor.Remove(op.Key)
+	case "clear":
+		editor.Clear()
+	case "incr":
+		editor.Increment(op.Key, op.Delta)
+	default:
+		return fmt.Errorf("unknown operation %q", op.Op)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package prefhttp
+
+import (
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"testing"
+)
+
+type BatchTestSuite struct {
+	serverSuite
+}
+
+func TestBatchTestSuite(t *testing.T) {
+	suite.Run(t, new(BatchTestSuite))
+}
+
+func (suite *BatchTestSuite) TestBatch() {
+	suite.p.Edit().Put("old", 1).Put("count", 5).Commit()
+	resp := suite.do("POST", "/batch", `[
+		{"op": "clear"},
+		{"op": "put", "key": "name", "value": "value"},
+		{"op": "put", "key": "id", "value": "7", "type": "uint64"},
+		{"op": "cas", "key": "lock", "value": "me"},
+		{"op": "incr", "key": "count", "delta": 2},
+		{"op": "ttl", "key": "token", "value": "abc", "ttl": "1h"},
+		{"op": "remove", "key": "name"}
+	]`)
+	suite.Equal(http.StatusNoContent, resp.StatusCode)
+	suite.Equal(map[string]interface{}{
+		"id":    uint64(7),
+		"lock":  "me",
+		"count": 2,
+		"token": "abc",
+	}, suite.p.GetAll())
+}
+
+func (suite *BatchTestSuite) TestConditionFailed() {
+	suite.p.Edit().Put("lock", "other").Commit()
+	resp := suite.do("POST", "/batch", `[
+		{"op": "cas", "key": "lock", "expected": "me", "value": "me"},
+		{"op": "put", "key": "name", "value": "value"}
+	]`)
+	suite.Equal(http.StatusConflict, resp.StatusCode)
+	var body struct {
+		Keys []string `json:"keys"`
+	}
+	suite.Nil(decode(resp, &body))
+	suite.Equal([]string{"lock"}, body.Keys)
+	suite.False(suite.p.Contains("name"))
+}
+
+func (suite *BatchTestSuite) TestInvalidOps() {
+	suite.Equal(http.StatusBadRequest, suite.do("POST", "/batch", `{}`).StatusCode)
+	suite.Equal(http.StatusBadRequest, suite.do("POST", "/batch", `[{"op": "put", "value": 1}]`).StatusCode)
+	suite.Equal(http.StatusBadRequest, suite.do("POST", "/batch", `[{"op": "move", "key": "a"}]`).StatusCode)
+	suite.Equal(http.StatusBadRequest, suite.do("POST", "/batch", `[{"op": "ttl", "key": "a", "value": 1, "ttl": "x"}]`).StatusCode)
+	suite.Empty(suite.p.GetAll())
+}
+
+func (suite *BatchTestSuite) TestIfMatch() {
+	suite.p.Edit().Put("key", 1).Commit()
+	resp := suite.do("POST", "/batch", `[{"op": "incr", "key": "key", "delta": 1}]`, "If-Match", suite.etag(0))
+	suite.Equal(http.StatusPreconditionFailed, resp.StatusCode)
+	resp = suite.do("POST", "/batch", `[{"op": "incr", "key": "key", "delta": 1}]`, "If-Match", suite.etag(1))
+	suite.Equal(http.StatusNoContent, resp.StatusCode)
+	suite.Equal(suite.etag(2), resp.Header.Get("ETag"))
+}
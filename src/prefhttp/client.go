@@ -0,0 +1,583 @@
+package prefhttp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"pref"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reconnectDelay is the delay before the event stream of a Client is connected again after it broke.
+const reconnectDelay = time.Second
+
+// StatusError is the error of a request which the server responded with an unexpected status.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("prefhttp: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Client is a Preferences served by a Handler on a remote server. It keeps a local cache of the key-values,
+// which is kept up to date by the change events of the server, so the getters never make a request. The
+// changes of an editor are sent as one batch when it is committed or applied, the batches are sent in order
+// by one worker. The listeners are notified of the keys changed on the server by anyone.
+type Client struct {
+	pref.TypedGetters
+	url        string
+	httpClient *http.Client
+	// snapshot holds the current *cache, which is replaced as a whole under cacheLock.
+	snapshot  atomic.Value
+	cacheLock *sync.Mutex
+	// defaults holds the map[string]interface{} of the default values set by SetDefaults.
+	defaults     atomic.Value
+	observers    map[chan string]interface{}
+	observerLock *sync.Mutex
+	cancel       context.CancelFunc
+	done         chan bool
+	// queue holds the batches waiting to be sent, they are sent in order by one worker which runs while
+	// sending is set.
+	queue     []func()
+	sending   bool
+	queueLock *sync.Mutex
+}
+
+// cache is an immutable version of the key-values of the server.
+type cache struct {
+	m map[string]interface{}
+	// epoch is the epoch of the server which the revision belongs to.
+	epoch    string
+	revision uint64
+}
+
+// ClientOption configures a Client when it is created by NewClient.
+type ClientOption func(*Client)
+
+// WithHTTPClient sets the http.Client which sends the requests, instead of http.DefaultClient. It must not
+// time out the event stream.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// NewClient creates a Client of the Preferences of a name served by the Handler at serverURL, such as
+// "http://host:8080". It loads the key-values before returning, and keeps them up to date until Close.
+func NewClient(serverURL string, name string, options ...ClientOption) (*Client, error) {
+	c := &Client{
+		url:          strings.TrimSuffix(serverURL, "/") + prefix + url.PathEscape(name),
+		httpClient:   http.DefaultClient,
+		cacheLock:    &sync.Mutex{},
+		observers:    make(map[chan string]interface{}),
+		observerLock: &sync.Mutex{},
+		done:         make(chan bool),
+		queueLock:    &sync.Mutex{},
+	}
+	c.TypedGetters = pref.TypedGetters{GetObjectFunc: c.GetObject}
+	c.snapshot.Store(&cache{m: make(map[string]interface{})})
+	c.defaults.Store(make(map[string]interface{}))
+	for _, option := range options {
+		option(c)
+	}
+	if err := c.Refresh(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	go c.streamEvents(ctx)
+	return c, nil
+}
+
+// Close stops receiving the change events from the server.
+func (c *Client) Close() {
+	c.cancel()
+	<-c.done
+}
+
+func (c *Client) current() *cache {
+	return c.snapshot.Load().(*cache)
+}
+
+// Refresh loads all the key-values from the server, and notifies the listeners of the changed keys.
+func (c *Client) Refresh() error {
+	resp, err := c.httpClient.Get(c.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	epoch, revision, err := parseETag(resp.Header.Get("ETag"))
+	if err != nil {
+		return err
+	}
+	var entries map[string]struct {
+		Value json.RawMessage `json:"value"`
+		Type  string          `json:"type"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return err
+	}
+	m := make(map[string]interface{}, len(entries))
+	for k, entry := range entries {
+		if m[k], err = DecodeValue(entry.Value, entry.Type); err != nil {
+			return fmt.Errorf("prefhttp: invalid value of key %s: %v", k, err)
+		}
+	}
+	c.cacheLock.Lock()
+	old := c.current()
+	if epoch == old.epoch && revision < old.revision {
+		// The events received meanwhile are newer than the response. The revisions of another epoch
+		// cannot be compared, so the cache is replaced.
+		c.cacheLock.Unlock()
+		return nil
+	}
+	keys := make([]string, 0)
+	for k, v := range m {
+		if ov, exist := old.m[k]; !exist || !reflect.DeepEqual(ov, v) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range old.m {
+		if _, exist := m[k]; !exist {
+			keys = append(keys, k)
+		}
+	}
+	c.snapshot.Store(&cache{m: m, epoch: epoch, revision: revision})
+	c.cacheLock.Unlock()
+	c.notifyObservers(keys)
+	return nil
+}
+
+// streamEvents applies the change events from the server to the cache until ctx is canceled. The cache is
+// refreshed every time the stream is connected, to catch up with the changes missed while disconnected.
+func (c *Client) streamEvents(ctx context.Context) {
+	defer close(c.done)
+	for {
+		if err := c.readEvents(ctx); err != nil && ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(reconnectDelay):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (c *Client) readEvents(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	if err := c.Refresh(); err != nil {
+		return err
+	}
+	r := bufio.NewReader(resp.Body)
+	event := ""
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			event = ""
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && event == "resync":
+			// The server dropped some changes, so all the key-values are read again.
+			if err := c.Refresh(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "data: "):
+			if err := c.applyEvent([]byte(strings.TrimPrefix(line, "data: "))); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// applyEvent applies the data of a change event to the cache, or refreshes the cache if the event skips a
+// revision.
+func (c *Client) applyEvent(data []byte) error {
+	var event struct {
+		Key      string          `json:"key"`
+		Value    json.RawMessage `json:"value"`
+		Type     string          `json:"type"`
+		Removed  bool            `json:"removed"`
+		Revision uint64          `json:"revision"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return err
+	}
+	var v interface{}
+	if !event.Removed {
+		var err error
+		if v, err = DecodeValue(event.Value, event.Type); err != nil {
+			return err
+		}
+	}
+	c.cacheLock.Lock()
+	old := c.current()
+	if event.Revision > old.revision+1 {
+		// The events of the revisions between have been missed, so all the key-values are read again.
+		c.cacheLock.Unlock()
+		return c.Refresh()
+	}
+	ov, exist := old.m[event.Key]
+	changed := event.Removed && exist || !event.Removed && (!exist || !reflect.DeepEqual(ov, v))
+	revision := old.revision
+	if event.Revision > revision {
+		revision = event.Revision
+	}
+	if changed || revision != old.revision {
+		m := make(map[string]interface{}, len(old.m))
+		for k, v := range old.m {
+			m[k] = v
+		}
+		if event.Removed {
+			delete(m, event.Key)
+		} else {
+			m[event.Key] = v
+		}
+		c.snapshot.Store(&cache{m: m, epoch: old.epoch, revision: revision})
+	}
+	c.cacheLock.Unlock()
+	if changed {
+		c.notifyObservers([]string{event.Key})
+	}
+	return nil
+}
+
+// localChange is a change of a key which Apply makes to the cache before the server confirms it.
+type localChange struct {
+	key      string
+	old, new interface{}
+	// existed and exist are whether the key exists before and after the change.
+	existed, exist bool
+}
+
+// applyLocally applies the operations of a batch to the cache, and returns the changes to roll back if the
+// server rejects it. The conditional puts and the increments depend on the values on the server, so they
+// are left to its change events.
+func (c *Client) applyLocally(ops []Op) []localChange {
+	c.cacheLock.Lock()
+	old := c.current()
+	m := make(map[string]interface{}, len(old.m))
+	cleared := false
+	for _, op := range ops {
+		cleared = cleared || op.Op == "clear"
+	}
+	// Clear removes the keys before the other changes of the batch, as it does on the server.
+	if !cleared {
+		for k, v := range old.m {
+			m[k] = v
+		}
+	}
+	for _, op := range ops {
+		switch op.Op {
+		case "put", "ttl":
+			if v, err := DecodeValue(op.Value, op.Type); err == nil {
+				m[op.Key] = v
+			}
+		case "remove":
+			delete(m, op.Key)
+		}
+	}
+	changes := make([]localChange, 0)
+	for k, v := range m {
+		if ov, existed := old.m[k]; !existed || !reflect.DeepEqual(ov, v) {
+			changes = append(changes, localChange{key: k, old: ov, new: v, existed: existed, exist: true})
+		}
+	}
+	for k, ov := range old.m {
+		if _, exist := m[k]; !exist {
+			changes = append(changes, localChange{key: k, old: ov, existed: true})
+		}
+	}
+	if len(changes) > 0 {
+		c.snapshot.Store(&cache{m: m, epoch: old.epoch, revision: old.revision})
+	}
+	c.cacheLock.Unlock()
+	c.notifyObservers(changedKeys(changes))
+	return changes
+}
+
+// rollback reverts the changes made by applyLocally, except for the keys changed again since.
+func (c *Client) rollback(changes []localChange) {
+	c.cacheLock.Lock()
+	old := c.current()
+	m := make(map[string]interface{}, len(old.m))
+	for k, v := range old.m {
+		m[k] = v
+	}
+	reverted := make([]localChange, 0)
+	for _, change := range changes {
+		if v, exist := m[change.key]; exist != change.exist || exist && !reflect.DeepEqual(v, change.new) {
+			continue
+		}
+		if change.existed {
+			m[change.key] = change.old
+		} else {
+			delete(m, change.key)
+		}
+		reverted = append(reverted, change)
+	}
+	if len(reverted) > 0 {
+		c.snapshot.Store(&cache{m: m, epoch: old.epoch, revision: old.revision})
+	}
+	c.cacheLock.Unlock()
+	c.notifyObservers(changedKeys(reverted))
+}
+
+func changedKeys(changes []localChange) []string {
+	keys := make([]string, 0, len(changes))
+	for _, change := range changes {
+		keys = append(keys, change.key)
+	}
+	return keys
+}
+
+// RegisterOnPreferenceChangeListener registers a listener of the keys changed on the server.
+func (c *Client) RegisterOnPreferenceChangeListener(observer pref.OnPreferenceChangeListener) {
+	c.observerLock.Lock()
+	defer c.observerLock.Unlock()
+	c.observers[observer] = nil
+}
+
+// UnregisterOnPreferenceChangeListener unregisters a listener.
+func (c *Client) UnregisterOnPreferenceChangeListener(observer pref.OnPreferenceChangeListener) {
+	c.observerLock.Lock()
+	defer c.observerLock.Unlock()
+	delete(c.observers, observer)
+}
+
+func (c *Client) notifyObservers(keys []string) {
+	c.observerLock.Lock()
+	defer c.observerLock.Unlock()
+	for _, key := range keys {
+		for ob := range c.observers {
+			select {
+			case ob <- key:
+			default:
+			}
+		}
+	}
+}
+
+// Contains returns whether a key exists in the cache.
+func (c *Client) Contains(key string) bool {
+	_, exist := c.current().m[key]
+	return exist
+}
+
+// GetObject returns the value in the cache, or the default value set by SetDefaults, or defaultValue.
+func (c *Client) GetObject(key string, defaultValue interface{}) interface{} {
+	if v, exist := c.current().m[key]; exist {
+		return v
+	}
+	if v, exist := c.defaults.Load().(map[string]interface{})[key]; exist {
+		return v
+	}
+	return defaultValue
+}
+
+// GetAll returns a copy of all the key-values in the cache.
+func (c *Client) GetAll() map[string]interface{} {
+	m := c.current().m
+	all := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		all[k] = v
+	}
+	return all
+}
+
+// Revision returns the revision of the server which the cache is at.
+func (c *Client) Revision() uint64 {
+	return c.current().revision
+}
+
+// SetDefaults registers the default values of keys locally, a nil value unregisters the default of a key.
+func (c *Client) SetDefaults(defaults map[string]interface{}) {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+	merged := make(map[string]interface{})
+	for k, v := range c.defaults.Load().(map[string]interface{}) {
+		merged[k] = v
+	}
+	for k, v := range defaults {
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	c.defaults.Store(merged)
+}
+
+// GetOrDefault returns the value of a key, or its registered default value, or nil if neither exists.
+func (c *Client) GetOrDefault(key string) interface{} {
+	return c.GetObject(key, nil)
+}
+
+// IsDefault returns whether the value of a key is its registered default value, either because the key has
+// not been set or because it has been set to the same value.
+func (c *Client) IsDefault(key string) bool {
+	v, exist := c.current().m[key]
+	if !exist {
+		return true
+	}
+	registered, exist := c.defaults.Load().(map[string]interface{})[key]
+	return exist && reflect.DeepEqual(v, registered)
+}
+
+// Edit creates an editor which commits its changes to the server.
+func (c *Client) Edit() pref.Editor {
+	return newClientEditor(c, "", 0, false)
+}
+
+// EditAt creates an editor whose commit fails with pref.ErrConflict if the Preferences on the server has
+// been changed since the revision.
+func (c *Client) EditAt(revision uint64) pref.Editor {
+	return newClientEditor(c, c.current().epoch, revision, true)
+}
+
+// maxUpdateAttempts is the number of times Update runs its function when the commits conflict.
+const maxUpdateAttempts = 10
+
+// Update runs fn in an optimistic transaction, which reads the cache and commits its writes only if the
+// Preferences on the server has not been changed since. On a conflict the cache is refreshed and fn is run
+// again, so fn must not have other side effects.
+func (c *Client) Update(fn func(pref.Tx) error) error {
+	var err error
+	for i := 0; i < maxUpdateAttempts; i++ {
+		snapshot := c.current()
+		editor := newClientEditor(c, snapshot.epoch, snapshot.revision, true)
+		tx := newClientTx(editor, snapshot.m)
+		if err = fn(tx); err != nil {
+			return err
+		}
+		if err = editor.commit(); err != pref.ErrConflict {
+			return err
+		}
+		if err := c.Refresh(); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// enqueue queues f to be run by the worker after the batches queued before, and starts the worker if it
+// is not running.
+func (c *Client) enqueue(f func()) {
+	c.queueLock.Lock()
+	defer c.queueLock.Unlock()
+	c.queue = append(c.queue, f)
+	if !c.sending {
+		c.sending = true
+		go c.send()
+	}
+}
+
+// send runs the queued functions in order until the queue is empty.
+func (c *Client) send() {
+	for {
+		c.queueLock.Lock()
+		if len(c.queue) == 0 {
+			c.sending = false
+			c.queueLock.Unlock()
+			return
+		}
+		f := c.queue[0]
+		c.queue = c.queue[1:]
+		c.queueLock.Unlock()
+		f()
+	}
+}
+
+// commit sends the operations as a batch after the batches queued before, so the server applies them in
+// the order they are committed and applied.
+func (c *Client) commit(ops []Op, epoch string, revision uint64, atRevision bool) error {
+	done := make(chan error, 1)
+	c.enqueue(func() {
+		done <- c.sendBatch(ops, epoch, revision, atRevision)
+	})
+	return <-done
+}
+
+// sendBatch sends the operations as a batch, and refreshes the cache so that the changes can be read at
+// once.
+func (c *Client) sendBatch(ops []Op, epoch string, revision uint64, atRevision bool) error {
+	body, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url+"/batch", strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if atRevision {
+		req.Header.Set("If-Match", formatETag(epoch, revision))
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return responseError(resp)
+	}
+	if epoch, revision, err := parseETag(resp.Header.Get("ETag")); err == nil && epoch == c.current().epoch && revision <= c.Revision() {
+		// The events of the changes have already been received.
+		return nil
+	}
+	return c.Refresh()
+}
+
+// responseError converts the error responded by a Handler back to the error of the commit.
+func responseError(resp *http.Response) error {
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	var body struct {
+		Error string   `json:"error"`
+		Keys  []string `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		body.Error = strings.TrimSpace(string(data))
+	}
+	switch resp.StatusCode {
+	case http.StatusPreconditionFailed:
+		return pref.ErrConflict
+	case http.StatusConflict:
+		if len(body.Keys) > 0 {
+			return &pref.ConditionError{Keys: body.Keys}
+		}
+	}
+	return &StatusError{StatusCode: resp.StatusCode, Message: body.Error}
+}
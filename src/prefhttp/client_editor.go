@@ -0,0 +1,243 @@
+package prefhttp
+
+import (
+	"encoding/json"
+	"log"
+	"pref"
+	"sync"
+	"time"
+)
+
+// clientEditor is the Editor of a Client, which keeps the changes as the operations of a batch.
+type clientEditor struct {
+	client     *Client
+	ops        []Op
+	revision   uint64
+	atRevision bool
+	// epoch is the epoch of the server which the revision belongs to.
+	epoch string
+	// err is the reason why the last commit failed, and putErr is the error of the first value which
+	// cannot be encoded, which fails every commit.
+	err    error
+	putErr error
+	*sync.Mutex
+}
+
+func newClientEditor(c *Client, epoch string, revision uint64, atRevision bool) *clientEditor {
+	return &clientEditor{
+		client:     c,
+		epoch:      epoch,
+		revision:   revision,
+		atRevision: atRevision,
+		Mutex:      &sync.Mutex{},
+	}
+}
+
+// encodeLocked encodes a value with its type name, and keeps the first error in putErr.
+func (e *clientEditor) encodeLocked(value interface{}) (json.RawMessage, string, bool) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		if e.putErr == nil {
+			e.putErr = err
+		}
+		return nil, "", false
+	}
+	return data, pref.TypeName(value), true
+}
+
+// Put sets the value of key, a value of a type not supported by the typed getters is sent as JSON.
+func (e *clientEditor) Put(key string, value interface{}) pref.Editor {
+	e.Lock()
+	defer e.Unlock()
+	if value == nil {
+		e.ops = append(e.ops, Op{Op: "remove", Key: key})
+	} else if data, typeName, ok := e.encodeLocked(value); ok {
+		e.ops = append(e.ops, Op{Op: "put", Key: key, Value: data, Type: typeName})
+	}
+	return e
+}
+
+// Remove removes key.
+func (e *clientEditor) Remove(key string) pref.Editor {
+	e.Lock()
+	defer e.Unlock()
+	e.ops = append(e.ops, Op{Op: "remove", Key: key})
+	return e
+}
+
+// Clear removes all the keys, the changes made after it are kept.
+func (e *clientEditor) Clear() pref.Editor {
+	e.Lock()
+	defer e.Unlock()
+	e.ops = append(e.ops, Op{Op: "clear"})
+	return e
+}
+
+// CompareAndPut sets the value of key only if its value on the server is still expected, see
+// pref.Editor.CompareAndPut.
+func (e *clientEditor) CompareAndPut(key string, expected interface{}, value interface{}) pref.Editor {
+	e.Lock()
+	defer e.Unlock()
+	data, typeName, ok := e.encodeLocked(value)
+	if !ok {
+		return e
+	}
+	op := Op{Op: "cas", Key: key, Value: data, Type: typeName}
+	if expected != nil {
+		if op.Expected, _, ok = e.encodeLocked(expected); !ok {
+			return e
+		}
+	}
+	e.ops = append(e.ops, op)
+	return e
+}
+
+// Increment adds delta to the numeric value of key on the server.
+func (e *clientEditor) Increment(key string, delta int64) pref.Editor {
+	e.Lock()
+	defer e.Unlock()
+	e.ops = append(e.ops, Op{Op: "incr", Key: key, Delta: delta})
+	return e
+}
+
+// PutWithTTL sets the value of key which expires after ttl.
+func (e *clientEditor) PutWithTTL(key string, value interface{}, ttl time.Duration) pref.Editor {
+	e.Lock()
+	defer e.Unlock()
+	if data, typeName, ok := e.encodeLocked(value); ok {
+		e.ops = append(e.ops, Op{Op: "ttl", Key: key, Value: data, Type: typeName, TTL: ttl.String()})
+	}
+	return e
+}
+
+// Commit sends the changes to the server as one batch, and returns whether it succeeded.
+func (e *clientEditor) Commit() bool {
+	e.Lock()
+	defer e.Unlock()
+	e.err = e.commitLocked()
+	return e.err == nil
+}
+
+// Apply changes the cache at once, and sends the changes to the server in the background after the
+// batches committed or applied before. If the server rejects them, the cache is rolled back and the failure
+// is logged, but not reported by Err.
+func (e *clientEditor) Apply() {
+	e.Lock()
+	defer e.Unlock()
+	ops, epoch, revision, atRevision := e.ops, e.epoch, e.revision, e.atRevision
+	e.ops = nil
+	if e.putErr != nil || len(ops) == 0 {
+		return
+	}
+	c := e.client
+	changes := c.applyLocally(ops)
+	c.enqueue(func() {
+		if err := c.sendBatch(ops, epoch, revision, atRevision); err != nil {
+			c.rollback(changes)
+			log.Printf("Error when apply to remote preference %s: %v", c.url, err)
+		}
+	})
+}
+
+// Err returns the reason why the last Commit failed, or nil if it succeeded.
+func (e *clientEditor) Err() error {
+	e.Lock()
+	defer e.Unlock()
+	return e.err
+}
+
+func (e *clientEditor) commit() error {
+	e.Lock()
+	defer e.Unlock()
+	return e.commitLocked()
+}
+
+// commitLocked sends the operations, they are only sent once like the changes of a local editor.
+func (e *clientEditor) commitLocked() error {
+	if e.putErr != nil {
+		return e.putErr
+	}
+	ops := e.ops
+	e.ops = nil
+	if len(ops) == 0 {
+		return nil
+	}
+	err := e.client.commit(ops, e.epoch, e.revision, e.atRevision)
+	if err == nil {
+		snapshot := e.client.current()
+		e.epoch, e.revision = snapshot.epoch, snapshot.revision
+	}
+	return err
+}
+
+// clientTx is the Tx of Client.Update, its reads see the cache with its own writes.
+type clientTx struct {
+	pref.TypedGetters
+	editor   *clientEditor
+	base     map[string]interface{}
+	modified map[string]interface{}
+	cleared  bool
+}
+
+func newClientTx(editor *clientEditor, base map[string]interface{}) *clientTx {
+	tx := &clientTx{editor: editor, base: base, modified: make(map[string]interface{})}
+	tx.TypedGetters = pref.TypedGetters{GetObjectFunc: tx.GetObject}
+	return tx
+}
+
+func (tx *clientTx) get(key string) (interface{}, bool) {
+	if v, exist := tx.modified[key]; exist {
+		// A nil value indicates the key has been removed.
+		return v, v != nil
+	}
+	if tx.cleared {
+		return nil, false
+	}
+	v, exist := tx.base[key]
+	return v, exist
+}
+
+// Contains returns whether a key exists with the writes of the transaction.
+func (tx *clientTx) Contains(key string) bool {
+	_, exist := tx.get(key)
+	return exist
+}
+
+// GetObject returns the value with the writes of the transaction, or the default value of the Client, or
+// defaultValue.
+func (tx *clientTx) GetObject(key string, defaultValue interface{}) interface{} {
+	if v, exist := tx.get(key); exist {
+		return v
+	}
+	if v, exist := tx.editor.client.defaults.Load().(map[string]interface{})[key]; exist {
+		return v
+	}
+	return defaultValue
+}
+
+// Put sets the value of key in the transaction.
+func (tx *clientTx) Put(key string, value interface{}) pref.Tx {
+	tx.modified[key] = value
+	tx.editor.Put(key, value)
+	return tx
+}
+
+// Remove removes the key in the transaction.
+func (tx *clientTx) Remove(key string) pref.Tx {
+	tx.modified[key] = nil
+	tx.editor.Remove(key)
+	return tx
+}
+
+// Clear removes all the keys in the transaction.
+func (tx *clientTx) Clear() pref.Tx {
+	tx.modified = make(map[string]interface{})
+	tx.cleared = true
+	// Drop the writes queued before clear, as the transaction of a local Preferences does, since the
+	// server applies the operations to one editor which would keep them.
+	tx.editor.Lock()
+	tx.editor.ops = nil
+	tx.editor.Unlock()
+	tx.editor.Clear()
+	return tx
+}
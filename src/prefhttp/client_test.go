@@ -0,0 +1,221 @@
+package prefhttp
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"pref"
+	"testing"
+	"time"
+)
+
+var _ pref.Preferences = (*Client)(nil)
+
+type ClientTestSuite struct {
+	serverSuite
+	client *Client
+}
+
+func (suite *ClientTestSuite) SetupTest() {
+	suite.serverSuite.SetupTest()
+	suite.p.Edit().Put("count", int64(3)).Put("name", "value").Commit()
+	client, err := NewClient(suite.server.URL, suite.name)
+	suite.Nil(err)
+	suite.client = client
+}
+
+func (suite *ClientTestSuite) TearDownTest() {
+	suite.client.Close()
+	suite.serverSuite.TearDownTest()
+}
+
+func TestClientTestSuite(t *testing.T) {
+	suite.Run(t, new(ClientTestSuite))
+}
+
+// waitFor waits for a key from the listener.
+func (suite *ClientTestSuite) waitFor(ch chan string, key string) {
+	for {
+		select {
+		case k := <-ch:
+			if k == key {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			suite.Fail("timeout waiting for key " + key)
+			return
+		}
+	}
+}
+
+func (suite *ClientTestSuite) TestRead() {
+	suite.Equal(int64(3), suite.client.GetInt64("count", 0))
+	suite.Equal("value", suite.client.GetString("name", ""))
+	suite.True(suite.client.Contains("name"))
+	suite.False(suite.client.Contains("missing"))
+	suite.Equal(uint64(1), suite.client.Revision())
+	suite.Equal(suite.p.GetAll(), suite.client.GetAll())
+}
+
+func (suite *ClientTestSuite) TestCommit() {
+	editor := suite.client.Edit()
+	suite.True(editor.Put("count", int64(4)).Put("tags", []string{"a", "b"}).Remove("name").Commit())
+	suite.Nil(editor.Err())
+	// The changes can be read at once after the commit.
+	suite.Equal(int64(4), suite.client.GetInt64("count", 0))
+	suite.False(suite.client.Contains("name"))
+	suite.Equal([]string{"a", "b"}, suite.client.GetObject("tags", nil))
+	suite.Equal(int64(4), suite.p.GetInt64("count", 0))
+	suite.Equal(suite.p.Revision(), suite.client.Revision())
+}
+
+func (suite *ClientTestSuite) TestRemoteChangeNotifiesListeners() {
+	ch := make(chan string, 8)
+	suite.client.RegisterOnPreferenceChangeListener(ch)
+	defer suite.client.UnregisterOnPreferenceChangeListener(ch)
+	suite.p.Edit().Put("name", "changed").Commit()
+	suite.waitFor(ch, "name")
+	suite.Equal("changed", suite.client.GetString("name", ""))
+
+	suite.p.Edit().Remove("count").Commit()
+	suite.waitFor(ch, "count")
+	suite.False(suite.client.Contains("count"))
+}
+
+func (suite *ClientTestSuite) TestConditions() {
+	editor := suite.client.Edit()
+	suite.False(editor.CompareAndPut("name", "other", "mine").Commit())
+	suite.Equal(&pref.ConditionError{Keys: []string{"name"}}, editor.Err())
+	suite.True(suite.client.Edit().CompareAndPut("name", "value", "mine").Increment("count", 2).Commit())
+	suite.Equal("mine", suite.client.GetString("name", ""))
+	suite.Equal(int64(5), suite.client.GetInt64("count", 0))
+
+	editor = suite.client.EditAt(suite.client.Revision() - 1)
+	suite.False(editor.Put("name", "late").Commit())
+	suite.Equal(pref.ErrConflict, editor.Err())
+}
+
+func (suite *ClientTestSuite) TestUpdateRetriesOnConflict() {
+	attempts := 0
+	err := suite.client.Update(func(tx pref.Tx) error {
+		attempts++
+		if attempts == 1 {
+			// Another writer changes the Preferences before the transaction commits.
+			suite.p.Edit().Put("count", int64(10)).Commit()
+		}
+		tx.Put("count", tx.GetInt64("count", 0)+1)
+		suite.Equal(tx.GetInt64("count", 0), tx.GetObject("count", nil))
+		return nil
+	})
+	suite.Nil(err)
+	suite.Equal(2, attempts)
+	suite.Equal(int64(11), suite.p.GetInt64("count", 0))
+}
+
+func (suite *ClientTestSuite) TestUpdateClear() {
+	err := suite.client.Update(func(tx pref.Tx) error {
+		tx.Put("key3", 3).Clear().Put("key2", 4)
+		return nil
+	})
+	suite.Nil(err)
+	suite.Equal(map[string]interface{}{"key2": 4}, suite.p.GetAll())
+	suite.Equal(suite.p.GetAll(), suite.client.GetAll())
+}
+
+func (suite *ClientTestSuite) TestServerRestarted() {
+	suite.True(suite.client.Edit().Put("count", int64(4)).Commit())
+	suite.True(suite.client.Edit().Put("count", int64(5)).Commit())
+	suite.Equal(uint64(3), suite.client.Revision())
+
+	// The server loads the Preferences again with a new epoch, its revisions start again.
+	pref.Release(suite.name)
+	p := pref.NewPreferences(suite.name)
+	suite.True(p.Edit().Put("name", "restarted").Commit())
+
+	// The ETag of the commit is not compared with the revision of the old epoch.
+	suite.True(suite.client.Edit().Put("count", int64(6)).Commit())
+	suite.Equal(uint64(2), suite.client.Revision())
+	suite.Equal("restarted", suite.client.GetString("name", ""))
+	suite.Equal(int64(6), suite.client.GetInt64("count", 0))
+
+	suite.True(p.Edit().Put("name", "changed").Commit())
+	suite.Nil(suite.client.Refresh())
+	suite.Equal("changed", suite.client.GetString("name", ""))
+}
+
+func (suite *ClientTestSuite) TestDefaults() {
+	suite.client.SetDefaults(map[string]interface{}{"missing": 7, "name": "value"})
+	suite.Equal(7, suite.client.GetInt("missing", 0))
+	suite.Equal(7, suite.client.GetOrDefault("missing"))
+	suite.True(suite.client.IsDefault("name"))
+	suite.False(suite.client.IsDefault("count"))
+}
+
+func (suite *ClientTestSuite) TestApply() {
+	ch := make(chan string, 8)
+	suite.client.RegisterOnPreferenceChangeListener(ch)
+	suite.client.Edit().Put("name", "applied").Apply()
+	suite.waitFor(ch, "name")
+	suite.Equal("applied", suite.client.GetString("name", ""))
+}
+
+func (suite *ClientTestSuite) TestApplyChangesCacheAtOnce() {
+	suite.client.Edit().Put("name", "applied").Remove("count").Apply()
+	suite.Equal("applied", suite.client.GetString("name", ""))
+	suite.False(suite.client.Contains("count"))
+	suite.client.Edit().Clear().Put("other", 1).Apply()
+	suite.Equal(map[string]interface{}{"other": 1}, suite.client.GetAll())
+}
+
+func (suite *ClientTestSuite) TestApplyRollsBack() {
+	ch := make(chan string, 8)
+	suite.client.RegisterOnPreferenceChangeListener(ch)
+	// The revision is outdated, so the server rejects the batch.
+	suite.client.EditAt(0).Put("name", "rejected").Apply()
+	suite.Equal("rejected", suite.client.GetString("name", ""))
+	suite.waitFor(ch, "name")
+	suite.waitFor(ch, "name")
+	suite.Equal("value", suite.client.GetString("name", ""))
+	suite.Equal("value", suite.p.GetString("name", ""))
+}
+
+func (suite *ClientTestSuite) TestApplyInOrder() {
+	for i := 0; i < 20; i++ {
+		suite.client.Edit().Put("count", int64(i)).Apply()
+	}
+	// The commit is sent after the applies.
+	suite.True(suite.client.Edit().Put("name", "committed").Commit())
+	suite.Equal(int64(19), suite.p.GetInt64("count", 0))
+	suite.Equal(suite.p.GetAll(), suite.client.GetAll())
+}
+
+func (suite *ClientTestSuite) TestMissedRevision() {
+	suite.p.Edit().Put("missed", true).Commit()
+	// The event skips a revision, so the cache is refreshed instead of applying it.
+	suite.Nil(suite.client.applyEvent([]byte(`{"key": "other", "value": 1, "type": "int", "revision": 9}`)))
+	suite.False(suite.client.Contains("other"))
+	suite.True(suite.client.GetBool("missed", false))
+	suite.Equal(suite.p.Revision(), suite.client.Revision())
+}
+
+func (suite *ClientTestSuite) TestResync() {
+	p := newBlockingPreferences(suite.p)
+	server := serve(p)
+	defer server.Close()
+	client, err := NewClient(server.URL, suite.name)
+	suite.Nil(err)
+	defer client.Close()
+	ch := make(chan string, eventBufferSize*4)
+	client.RegisterOnPreferenceChangeListener(ch)
+	suite.p.Edit().Put("connected", true).Commit()
+	suite.waitFor(ch, "connected")
+
+	// The last keys are dropped by the event stream, and read again after the resync event.
+	suite.pileUp(p)
+	suite.waitFor(ch, fmt.Sprintf("key%d", eventBufferSize*2-1))
+	suite.Equal(suite.p.GetAll(), client.GetAll())
+}
+
+func (suite *ClientTestSuite) TestNotFound() {
+	_, err := NewClient(suite.server.URL, "..")
+	suite.IsType(&StatusError{}, err)
+}
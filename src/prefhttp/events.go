@@ -0,0 +1,95 @@
+package prefhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"pref"
+	"time"
+)
+
+// keepAliveInterval is the interval of the comments sent to keep an idle event stream open.
+const keepAliveInterval = 15 * time.Second
+
+// Event is the data of a server-sent event of a changed key, Removed is set if the key has been removed.
+// The data of a "resync" event only has the Revision.
+type Event struct {
+	Key      string      `json:"key"`
+	Value    interface{} `json:"value,omitempty"`
+	Type     string      `json:"type,omitempty"`
+	Removed  bool        `json:"removed,omitempty"`
+	Revision uint64      `json:"revision"`
+}
+
+// eventBufferSize is the number of the changed keys buffered for an event stream. The changes are dropped
+// when the buffer is full, and a "resync" event is sent instead.
+const eventBufferSize = 64
+
+// serveEvents streams an event "change" for every changed key until the client disconnects, the id of an
+// event is the revision after the change. If the stream falls behind and the changes may be dropped, an
+// event "resync" with the current revision is sent instead, the client has to read all the key-values again.
+func serveEvents(w http.ResponseWriter, r *http.Request, p pref.Preferences) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errorf(http.StatusNotImplemented, "streaming not supported")
+	}
+	ch := make(chan string, eventBufferSize)
+	p.RegisterOnPreferenceChangeListener(ch)
+	defer p.UnregisterOnPreferenceChangeListener(ch)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+		case key := <-ch:
+			var err error
+			if len(ch) >= cap(ch)-1 {
+				// The buffer was full, so the changes after it may have been dropped.
+				err = writeResync(w, ch, p)
+			} else {
+				err = writeChange(w, key, pref.Freeze(p))
+			}
+			if err != nil {
+				return nil
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeChange writes the event of a changed key with its value in the snapshot.
+func writeChange(w http.ResponseWriter, key string, snapshot pref.Preferences) error {
+	event := Event{Key: key, Revision: snapshot.Revision()}
+	if v, exist := snapshot.GetAll()[key]; exist {
+		entry := entryOf(v)
+		event.Value, event.Type = entry.Value, entry.Type
+	} else {
+		event.Removed = true
+	}
+	data, err := json.Marshal(&event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: change\ndata: %s\n\n", event.Revision, data)
+	return err
+}
+
+// writeResync drops the buffered keys, and writes the event which tells the client to read all the
+// key-values again.
+func writeResync(w http.ResponseWriter, ch chan string, p pref.Preferences) error {
+	for len(ch) > 0 {
+		<-ch
+	}
+	revision := p.Revision()
+	_, err := fmt.Fprintf(w, "id: %d\nevent: resync\ndata: {\"revision\":%d}\n\n", revision, revision)
+	return err
+}
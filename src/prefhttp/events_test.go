@@ -0,0 +1,133 @@
+package prefhttp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"pref"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type EventsTestSuite struct {
+	serverSuite
+}
+
+func TestEventsTestSuite(t *testing.T) {
+	suite.Run(t, new(EventsTestSuite))
+}
+
+// next reads the next event from the stream.
+func next(r *bufio.Reader) (id string, event string, data Event, err error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", "", Event{}, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return id, event, data, nil
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &data); err != nil {
+				return "", "", Event{}, err
+			}
+		}
+	}
+}
+
+func (suite *EventsTestSuite) TestEvents() {
+	resp := suite.do("GET", "", "", "Accept", "text/event-stream")
+	defer resp.Body.Close()
+	suite.Equal(http.StatusOK, resp.StatusCode)
+	suite.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+	suite.p.Edit().Put("key", 1).Commit()
+	r := bufio.NewReader(resp.Body)
+	id, event, data, err := next(r)
+	suite.Nil(err)
+	suite.Equal("1", id)
+	suite.Equal("change", event)
+	suite.Equal(Event{Key: "key", Value: 1.0, Type: "int", Revision: 1}, data)
+
+	suite.p.Edit().Remove("key").Commit()
+	_, _, data, err = next(r)
+	suite.Nil(err)
+	suite.Equal(Event{Key: "key", Removed: true, Revision: 2}, data)
+}
+
+// blockingPreferences blocks the first read of its revision after it is armed until release is closed, so
+// the changes can pile up meanwhile.
+type blockingPreferences struct {
+	pref.Preferences
+	armed   int32
+	blocked chan bool
+	release chan bool
+}
+
+func newBlockingPreferences(p pref.Preferences) *blockingPreferences {
+	return &blockingPreferences{Preferences: p, blocked: make(chan bool), release: make(chan bool)}
+}
+
+func (p *blockingPreferences) arm() {
+	atomic.StoreInt32(&p.armed, 1)
+}
+
+func (p *blockingPreferences) Revision() uint64 {
+	if atomic.CompareAndSwapInt32(&p.armed, 1, 0) {
+		close(p.blocked)
+		<-p.release
+	}
+	return p.Preferences.Revision()
+}
+
+// serve serves the Preferences for any name.
+func serve(p pref.Preferences) *httptest.Server {
+	return httptest.NewServer(NewHandler(WithOpener(func(name string, create bool) (pref.Preferences, error) {
+		return p, nil
+	})))
+}
+
+// pileUp commits a change which blocks the event stream of p, and then more changes than it buffers.
+func (suite *serverSuite) pileUp(p *blockingPreferences) {
+	p.arm()
+	suite.p.Edit().Put("key", 0).Commit()
+	<-p.blocked
+	editor := suite.p.Edit()
+	for i := 0; i < eventBufferSize*2; i++ {
+		editor.Put(fmt.Sprintf("key%d", i), i)
+	}
+	editor.Commit()
+	close(p.release)
+}
+
+func (suite *EventsTestSuite) TestResync() {
+	p := newBlockingPreferences(suite.p)
+	server := serve(p)
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL+"/prefs/"+suite.name, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	suite.Nil(err)
+	defer resp.Body.Close()
+
+	suite.pileUp(p)
+	r := bufio.NewReader(resp.Body)
+	_, event, data, err := next(r)
+	suite.Nil(err)
+	suite.Equal("change", event)
+	suite.Equal("key", data.Key)
+	id, event, data, err := next(r)
+	suite.Nil(err)
+	suite.Equal("resync", event)
+	suite.Equal("2", id)
+	suite.Equal(Event{Revision: 2}, data)
+}
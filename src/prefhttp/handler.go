@@ -0,0 +1,367 @@
+// Package prefhttp serves the Preferences of the pref package over HTTP/JSON, and provides a client of
+// them.
+package prefhttp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"pref"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prefix is the path prefix of the Preferences served by Handler.
+const prefix = "/prefs/"
+
+// maxBodySize is the maximum size in bytes of the body of a request.
+const maxBodySize = 1 << 20
+
+// processEpoch is chosen randomly when the package is loaded, it is the epoch of the Preferences which do
+// not have their own, see epochOf.
+var processEpoch = newEpoch()
+
+// Entry is the JSON representation of a value, Type is the type name returned by pref.TypeName. A value
+// without a type, such as a slice, is converted by pref.FromJSON.
+type Entry struct {
+	Value interface{} `json:"value"`
+	Type  string      `json:"type,omitempty"`
+}
+
+// Handler serves the Preferences over HTTP/JSON:
+//
+//	GET    /prefs/{name}        all the entries of a Preferences, or the change events if the request
+//	                            accepts text/event-stream
+//	GET    /prefs/{name}/{key}  the entry of a key
+//	PUT    /prefs/{name}/{key}  set the entry of a key
+//	DELETE /prefs/{name}/{key}  remove a key
+//	POST   /prefs/{name}/batch  commit a list of operations with one editor, see Op
+//
+// Only the Preferences returned by pref.Names are served by default, a PUT or POST with the query
+// "create=true" creates the Preferences if it does not exist.
+//
+// The ETag of a response is the revision of the Preferences with its epoch, since the revisions start again
+// when the Preferences is loaded again. A write with If-Match is only committed if the Preferences is still
+// at that revision of the same epoch, otherwise it fails with 412 Precondition Failed.
+type Handler struct {
+	open func(name string, create bool) (pref.Preferences, error)
+}
+
+// Option configures a Handler when it is created by NewHandler.
+type Option func(*Handler)
+
+// WithOpener sets the function which opens the Preferences of a name, instead of the one which only opens
+// the Preferences returned by pref.Names. create is set if the request asks to create the Preferences. It
+// can restrict the Preferences served by returning an error, which is responded as 404 Not Found.
+func WithOpener(open func(name string, create bool) (pref.Preferences, error)) Option {
+	return func(h *Handler) {
+		h.open = open
+	}
+}
+
+// NewHandler creates a Handler, which serves the Preferences under the base path by default.
+func NewHandler(options ...Option) *Handler {
+	h := &Handler{open: openExisting}
+	for _, option := range options {
+		option(h)
+	}
+	return h
+}
+
+// openExisting opens a Preferences returned by pref.Names, or creates it if create is set.
+func openExisting(name string, create bool) (pref.Preferences, error) {
+	if !create {
+		names, err := pref.Names()
+		if err != nil {
+			return nil, err
+		}
+		if i := sort.SearchStrings(names, name); i == len(names) || names[i] != name {
+			return nil, fmt.Errorf("preference %s not found", name)
+		}
+	}
+	return pref.NewPreferences(name), nil
+}
+
+// httpError is an error responded with its status code.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func (e *httpError) Unwrap() error {
+	return e.err
+}
+
+func errorf(status int, format string, args ...interface{}) error {
+	return &httpError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+// decodeBody decodes the JSON body of a request into v.
+func decodeBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return errorf(http.StatusRequestEntityTooLarge, "body larger than %d bytes", tooLarge.Limit)
+		}
+		return errorf(http.StatusBadRequest, "invalid body: %v", err)
+	}
+	return nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if err := h.serve(w, r); err != nil {
+		writeError(w, err)
+	}
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) error {
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, prefix) {
+		return errorf(http.StatusNotFound, "not found")
+	}
+	// The key is the rest of the path, so it may contain an escaped or unescaped slash.
+	parts := strings.SplitN(strings.TrimPrefix(path, prefix), "/", 2)
+	name, err := url.PathUnescape(parts[0])
+	if err != nil || !validName(name) {
+		return errorf(http.StatusNotFound, "invalid preference name %q", parts[0])
+	}
+	create := r.URL.Query().Get("create") == "true" && (r.Method == http.MethodPut || r.Method == http.MethodPost)
+	p, err := h.open(name, create)
+	if err != nil {
+		return &httpError{status: http.StatusNotFound, err: err}
+	}
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			return errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		}
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			return serveEvents(w, r, p)
+		}
+		return serveAll(w, r, p)
+	}
+	key, err := url.PathUnescape(parts[1])
+	if err != nil || key == "" {
+		return errorf(http.StatusNotFound, "invalid key %q", parts[1])
+	}
+	switch {
+	case key == "batch" && r.Method == http.MethodPost:
+		return serveBatch(w, r, p)
+	case r.Method == http.MethodGet:
+		return serveGet(w, r, p, key)
+	case r.Method == http.MethodPut:
+		return servePut(w, r, p, key)
+	case r.Method == http.MethodDelete:
+		editor, err := editorOf(r, p)
+		if err != nil {
+			return err
+		}
+		return commit(w, p, editor.Remove(key))
+	}
+	return errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+}
+
+// validName rejects the names which would escape the base path of the Preferences.
+func validName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`)
+}
+
+func serveAll(w http.ResponseWriter, r *http.Request, p pref.Preferences) error {
+	// The key-values are read from one snapshot, so that the ETag describes them.
+	snapshot := pref.Freeze(p)
+	all := snapshot.GetAll()
+	if notModified(w, r, epochOf(p), snapshot.Revision()) {
+		return nil
+	}
+	entries := make(map[string]Entry, len(all))
+	for k, v := range all {
+		entries[k] = entryOf(v)
+	}
+	return writeJSON(w, http.StatusOK, entries)
+}
+
+func serveGet(w http.ResponseWriter, r *http.Request, p pref.Preferences, key string) error {
+	snapshot := pref.Freeze(p)
+	v, exist := snapshot.GetAll()[key]
+	if !exist {
+		return errorf(http.StatusNotFound, "key %s not found", key)
+	}
+	if notModified(w, r, epochOf(p), snapshot.Revision()) {
+		return nil
+	}
+	return writeJSON(w, http.StatusOK, entryOf(v))
+}
+
+func servePut(w http.ResponseWriter, r *http.Request, p pref.Preferences, key string) error {
+	var body struct {
+		Value json.RawMessage `json:"value"`
+		Type  string          `json:"type"`
+	}
+	if err := decodeBody(r, &body); err != nil {
+		return err
+	}
+	v, err := DecodeValue(body.Value, body.Type)
+	if err != nil {
+		return &httpError{status: http.StatusBadRequest, err: err}
+	}
+	editor, err := editorOf(r, p)
+	if err != nil {
+		return err
+	}
+	return commit(w, p, editor.Put(key, v))
+}
+
+// editorOf creates an editor of the Preferences, at the revision of If-Match if it is set.
+func editorOf(r *http.Request, p pref.Preferences) (pref.Editor, error) {
+	match := r.Header.Get("If-Match")
+	if match == "" || match == "*" {
+		return p.Edit(), nil
+	}
+	etagEpoch, revision, err := parseETag(match)
+	if err != nil {
+		return nil, &httpError{status: http.StatusBadRequest, err: err}
+	}
+	if etagEpoch != epochOf(p) {
+		return nil, &httpError{status: http.StatusPreconditionFailed, err: pref.ErrConflict}
+	}
+	return p.EditAt(revision), nil
+}
+
+// commit commits the editor, and responds 204 No Content with the new ETag if it succeeded.
+func commit(w http.ResponseWriter, p pref.Preferences, editor pref.Editor) error {
+	if !editor.Commit() {
+		return commitError(editor.Err())
+	}
+	w.Header().Set("ETag", formatETag(epochOf(p), p.Revision()))
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// commitError maps the error of a failed commit to its status code.
+func commitError(err error) error {
+	var condition *pref.ConditionError
+	var validation *pref.ValidationError
+	switch {
+	case err == pref.ErrConflict:
+		return &httpError{status: http.StatusPreconditionFailed, err: err}
+	case errors.As(err, &condition):
+		return &httpError{status: http.StatusConflict, err: err}
+	case errors.As(err, &validation):
+		return &httpError{status: http.StatusUnprocessableEntity, err: err}
+	}
+	return &httpError{status: http.StatusInternalServerError, err: err}
+}
+
+// notModified responds 304 Not Modified if If-None-Match is the current revision, otherwise it sets the
+// ETag of the response.
+func notModified(w http.ResponseWriter, r *http.Request, epoch string, revision uint64) bool {
+	etag := formatETag(epoch, revision)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// epochOf returns the epoch of the revisions of p, such as the one of a *pref.PreferencesImpl.
+func epochOf(p pref.Preferences) string {
+	if e, ok := p.(interface {
+		Epoch() string
+	}); ok {
+		return e.Epoch()
+	}
+	return processEpoch
+}
+
+func newEpoch() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// formatETag formats the ETag of a revision in the form "epoch-revision".
+func formatETag(epoch string, revision uint64) string {
+	return strconv.Quote(epoch + "-" + strconv.FormatUint(revision, 10))
+}
+
+func parseETag(etag string) (string, uint64, error) {
+	text, err := strconv.Unquote(strings.TrimPrefix(etag, "W/"))
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid ETag %s", etag)
+	}
+	i := strings.LastIndex(text, "-")
+	if i < 0 {
+		return "", 0, fmt.Errorf("invalid ETag %s", etag)
+	}
+	revision, err := strconv.ParseUint(text[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid ETag %s", etag)
+	}
+	return text[:i], revision, nil
+}
+
+// entryOf returns the entry of a value with its type name.
+func entryOf(v interface{}) Entry {
+	return Entry{Value: v, Type: pref.TypeName(v)}
+}
+
+// DecodeValue decodes the JSON value of an Entry with its type name, a value without a type is converted
+// by pref.FromJSON.
+func DecodeValue(data json.RawMessage, typeName string) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, errors.New("prefhttp: missing value")
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, errors.New("prefhttp: null value")
+	}
+	if typeName == "" {
+		return pref.FromJSON(v), nil
+	}
+	switch v.(type) {
+	case string, json.Number, bool:
+		return pref.ParseValue(typeName, fmt.Sprint(v))
+	}
+	return nil, fmt.Errorf("prefhttp: expected a %s but got %s", typeName, data)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var herr *httpError
+	if errors.As(err, &herr) {
+		status = herr.status
+	}
+	response := struct {
+		Error string   `json:"error"`
+		Keys  []string `json:"keys,omitempty"`
+	}{Error: err.Error()}
+	var condition *pref.ConditionError
+	if errors.As(err, &condition) {
+		response.Keys = condition.Keys
+	}
+	writeJSON(w, status, &response)
+}
@@ -0,0 +1,224 @@
+package prefhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pref"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+var (
+	// prefSeq makes the names of the Preferences unique among the tests, since they are cached by name.
+	prefSeq uint64
+	// baseDir is the base path shared by the tests, it is only set once since the Preferences of the
+	// previous tests may still be writing to it.
+	baseDir  string
+	baseOnce sync.Once
+)
+
+// serverSuite serves a new Preferences for each test.
+type serverSuite struct {
+	suite.Suite
+	name   string
+	p      pref.Preferences
+	server *httptest.Server
+}
+
+func (suite *serverSuite) SetupTest() {
+	baseOnce.Do(func() {
+		baseDir, _ = ioutil.TempDir("", "prefhttp")
+		pref.InitBasePath(baseDir + string(filepath.Separator))
+	})
+	suite.name = fmt.Sprintf("handler_%d", atomic.AddUint64(&prefSeq, 1))
+	suite.p = pref.NewPreferences(suite.name)
+	suite.server = httptest.NewServer(NewHandler())
+}
+
+func (suite *serverSuite) TearDownTest() {
+	suite.server.Close()
+	paths, _ := filepath.Glob(filepath.Join(baseDir, suite.name+"*"))
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
+
+func (suite *serverSuite) do(method, path string, body string, header ...string) *http.Response {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, _ := http.NewRequest(method, suite.server.URL+"/prefs/"+suite.name+path, r)
+	for i := 0; i < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	suite.Nil(err)
+	return resp
+}
+
+// etag returns the ETag of a revision of the Preferences.
+func (suite *serverSuite) etag(revision uint64) string {
+	return formatETag(epochOf(suite.p), revision)
+}
+
+func decode(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type HandlerTestSuite struct {
+	serverSuite
+}
+
+func TestHandlerTestSuite(t *testing.T) {
+	suite.Run(t, new(HandlerTestSuite))
+}
+
+func (suite *HandlerTestSuite) TestGetAll() {
+	suite.p.Edit().Put("count", int64(3)).Put("name", "value").Put("tags", []string{"a"}).Commit()
+	resp := suite.do("GET", "", "")
+	suite.Equal(http.StatusOK, resp.StatusCode)
+	suite.Equal(suite.etag(1), resp.Header.Get("ETag"))
+	var entries map[string]Entry
+	suite.Nil(decode(resp, &entries))
+	suite.Equal(map[string]Entry{
+		"count": {Value: 3.0, Type: "int64"},
+		"name":  {Value: "value", Type: "string"},
+		"tags":  {Value: []interface{}{"a"}},
+	}, entries)
+
+	resp = suite.do("GET", "", "", "If-None-Match", suite.etag(1))
+	suite.Equal(http.StatusNotModified, resp.StatusCode)
+}
+
+func (suite *HandlerTestSuite) TestPutGetDelete() {
+	resp := suite.do("PUT", "/a%2Fb", `{"value": 42, "type": "int64"}`)
+	suite.Equal(http.StatusNoContent, resp.StatusCode)
+	suite.Equal(suite.etag(1), resp.Header.Get("ETag"))
+	suite.Equal(int64(42), suite.p.GetInt64("a/b", 0))
+
+	var entry Entry
+	suite.Nil(decode(suite.do("GET", "/a%2Fb", ""), &entry))
+	suite.Equal(Entry{Value: 42.0, Type: "int64"}, entry)
+
+	resp = suite.do("PUT", "/list", `{"value": [1, 2]}`)
+	suite.Equal(http.StatusNoContent, resp.StatusCode)
+	suite.Equal([]int{1, 2}, suite.p.GetObject("list", nil))
+
+	resp = suite.do("DELETE", "/a%2Fb", "")
+	suite.Equal(http.StatusNoContent, resp.StatusCode)
+	suite.False(suite.p.Contains("a/b"))
+	suite.Equal(http.StatusNotFound, suite.do("GET", "/a%2Fb", "").StatusCode)
+}
+
+func (suite *HandlerTestSuite) TestIfMatch() {
+	suite.p.Edit().Put("key", "v1").Commit()
+	resp := suite.do("PUT", "/key", `{"value": "v2"}`, "If-Match", suite.etag(1))
+	suite.Equal(http.StatusNoContent, resp.StatusCode)
+	resp = suite.do("PUT", "/key", `{"value": "v3"}`, "If-Match", suite.etag(1))
+	suite.Equal(http.StatusPreconditionFailed, resp.StatusCode)
+	resp = suite.do("DELETE", "/key", "", "If-Match", suite.etag(1))
+	suite.Equal(http.StatusPreconditionFailed, resp.StatusCode)
+	suite.Equal("v2", suite.p.GetString("key", ""))
+	resp = suite.do("PUT", "/key", `{"value": "v3"}`, "If-Match", "bad")
+	suite.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	// The revision of another epoch, such as before a restart, does not match.
+	resp = suite.do("PUT", "/key", `{"value": "v3"}`, "If-Match", formatETag("other", 2))
+	suite.Equal(http.StatusPreconditionFailed, resp.StatusCode)
+	resp = suite.do("GET", "", "", "If-None-Match", formatETag("other", 2))
+	suite.Equal(http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+	suite.Equal("v2", suite.p.GetString("key", ""))
+}
+
+func (suite *HandlerTestSuite) TestIfMatchAfterReload() {
+	suite.p.Edit().Put("key", "v1").Commit()
+	etag := suite.etag(1)
+	// The revisions start again when the Preferences is loaded again.
+	pref.Release(suite.name)
+	suite.p = pref.NewPreferences(suite.name)
+	suite.True(suite.p.Edit().Put("key", "v2").Commit())
+	suite.Equal(uint64(1), suite.p.Revision())
+
+	resp := suite.do("PUT", "/key", `{"value": "v3"}`, "If-Match", etag)
+	suite.Equal(http.StatusPreconditionFailed, resp.StatusCode)
+	suite.Equal("v2", suite.p.GetString("key", ""))
+}
+
+func (suite *HandlerTestSuite) TestErrors() {
+	suite.Equal(http.StatusBadRequest, suite.do("PUT", "/key", `{"value": "x", "type": "int"}`).StatusCode)
+	suite.Equal(http.StatusBadRequest, suite.do("PUT", "/key", `not json`).StatusCode)
+	suite.Equal(http.StatusBadRequest, suite.do("PUT", "/key", `{"value": null}`).StatusCode)
+	suite.Equal(http.StatusMethodNotAllowed, suite.do("POST", "", "").StatusCode)
+	suite.Equal(http.StatusMethodNotAllowed, suite.do("PATCH", "/key", "").StatusCode)
+
+	resp, err := http.Get(suite.server.URL + "/prefs/..%2Fescape")
+	suite.Nil(err)
+	suite.Equal(http.StatusNotFound, resp.StatusCode)
+	resp, err = http.Get(suite.server.URL + "/other")
+	suite.Nil(err)
+	suite.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (suite *HandlerTestSuite) TestCreate() {
+	path := suite.server.URL + "/prefs/" + suite.name + "_new"
+	defer pref.Release(suite.name + "_new")
+	resp, err := http.Get(path)
+	suite.Nil(err)
+	suite.Equal(http.StatusNotFound, resp.StatusCode)
+	req, _ := http.NewRequest("PUT", path+"/key", strings.NewReader(`{"value": "v"}`))
+	resp, err = http.DefaultClient.Do(req)
+	suite.Nil(err)
+	suite.Equal(http.StatusNotFound, resp.StatusCode)
+
+	req, _ = http.NewRequest("PUT", path+"/key?create=true", strings.NewReader(`{"value": "v"}`))
+	resp, err = http.DefaultClient.Do(req)
+	suite.Nil(err)
+	suite.Equal(http.StatusNoContent, resp.StatusCode)
+	resp, err = http.Get(path + "/key")
+	suite.Nil(err)
+	suite.Equal(http.StatusOK, resp.StatusCode)
+}
+
+func (suite *HandlerTestSuite) TestBodyTooLarge() {
+	body := `{"value": "` + strings.Repeat("x", maxBodySize) + `"}`
+	suite.Equal(http.StatusRequestEntityTooLarge, suite.do("PUT", "/key", body).StatusCode)
+	suite.Equal("", suite.p.GetString("key", ""))
+}
+
+func (suite *HandlerTestSuite) TestValidationError() {
+	schema := pref.NewSchema().Define("port", pref.KeySpec{Default: 80, Min: 1, Max: 65535})
+	p := pref.NewPreferences(suite.name+"_schema", pref.WithSchema(schema))
+	server := httptest.NewServer(NewHandler(WithOpener(func(name string, create bool) (pref.Preferences, error) {
+		if name != suite.name+"_schema" {
+			return nil, fmt.Errorf("preference %s not found", name)
+		}
+		return p, nil
+	})))
+	defer server.Close()
+
+	req, _ := http.NewRequest("PUT", server.URL+"/prefs/"+suite.name+"_schema/port", strings.NewReader(`{"value": 0}`))
+	resp, err := http.DefaultClient.Do(req)
+	suite.Nil(err)
+	suite.Equal(http.StatusUnprocessableEntity, resp.StatusCode)
+	var body struct {
+		Error string `json:"error"`
+	}
+	suite.Nil(decode(resp, &body))
+	suite.Contains(body.Error, "less than the minimum")
+
+	resp, err = http.Get(server.URL + "/prefs/" + suite.name)
+	suite.Nil(err)
+	suite.Equal(http.StatusNotFound, resp.StatusCode)
+}